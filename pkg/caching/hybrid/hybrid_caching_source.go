@@ -2,62 +2,117 @@ package hybrid
 
 import (
 	"context"
+	"fmt"
 	ds "github.com/ereb-or-od/kenobi/pkg/caching/distributed/interfaces"
 	"github.com/ereb-or-od/kenobi/pkg/caching/hybrid/interfaces"
 	in "github.com/ereb-or-od/kenobi/pkg/caching/inmemory/interfaces"
+	"reflect"
 	"time"
 )
 
 type hybridCachingSource struct {
 	inmemoryCachingSource    in.InMemoryCachingSource
 	distributedCachingSource ds.DistributedCachingSource
+	inmemoryExpiration       time.Duration
+}
+
+// distributedEntry wraps the values stored in the distributed cache, Cached tells a hit from a miss.
+// The entries are stored under distributedKeyPrefix, so the values stored unwrapped by the previous versions
+// are never decoded as entries and processes of both versions running side by side don't read each other's values.
+type distributedEntry struct {
+	Cached bool        `json:"cached"`
+	Value  interface{} `json:"value"`
+}
+
+// distributedKeyPrefix versions the keys of the distributedEntry values.
+const distributedKeyPrefix = "hybrid:v2:"
+
+type Option func(h *hybridCachingSource)
+
+// WithInMemoryExpiration caps how long a value stays in the in-memory cache.
+// A value deleted by another process is served from memory until it expires. Default: 1 minute.
+func WithInMemoryExpiration(expiration time.Duration) Option {
+	return func(h *hybridCachingSource) {
+		h.inmemoryExpiration = expiration
+	}
 }
 
 func (h hybridCachingSource) GetOrSetValueByKey(ctx context.Context, key string, expiration time.Duration, callbackFunc func() (interface{}, error)) (interface{}, error) {
-	if dataInInMemoryCacheStore, err := h.inmemoryCachingSource.GetValueByKey(key); err != nil {
+	var value interface{}
+	if found, err := h.GetValueByKey(ctx, key, &value); err != nil {
+		return nil, err
+	} else if found {
+		return value, nil
+	}
+
+	callbackResult, err := callbackFunc()
+	if err != nil {
+		return nil, err
+	}
+	if err = h.SetValue(ctx, key, callbackResult, expiration); err != nil {
 		return nil, err
-	} else {
-		if dataInInMemoryCacheStore == nil {
-			var dataInDistributedCacheStore interface{}
-			if err = h.distributedCachingSource.GetValueByKey(ctx, key, &dataInDistributedCacheStore); err != nil {
-				return nil, err
-			} else {
-				if dataInDistributedCacheStore == nil {
-					if callbackResult, err := callbackFunc(); err != nil {
-						return nil, err
-					} else {
-						if err = h.SetValue(ctx, key, callbackResult, expiration); err != nil {
-							return nil, err
-						}
-						return callbackResult, nil
-					}
-				} else {
-					if err = h.inmemoryCachingSource.SetValue(key, dataInDistributedCacheStore); err != nil {
-						return nil, err
-					}
-					return dataInDistributedCacheStore, nil
-				}
-			}
-		} else {
-			return dataInInMemoryCacheStore, nil
+	}
+	return callbackResult, nil
+}
+
+func (h hybridCachingSource) GetValueByKey(ctx context.Context, key string, result interface{}) (bool, error) {
+	resultValue := reflect.ValueOf(result)
+	if resultValue.Kind() != reflect.Ptr || resultValue.IsNil() {
+		return false, fmt.Errorf("result must be a not nil pointer")
+	}
+
+	if dataInInMemoryCacheStore := h.inmemoryCachingSource.GetValueByKey(key); dataInInMemoryCacheStore != nil {
+		if data := reflect.ValueOf(dataInInMemoryCacheStore); data.Type().AssignableTo(resultValue.Elem().Type()) {
+			resultValue.Elem().Set(data)
+			return true, nil
 		}
 	}
+
+	entry := &distributedEntry{Value: result}
+	if err := h.distributedCachingSource.GetValueByKey(ctx, distributedKeyPrefix+key, entry); err != nil {
+		return false, err
+	}
+	if !entry.Cached {
+		return false, nil
+	}
+
+	if err := h.inmemoryCachingSource.SetValueWithExpiration(key, resultValue.Elem().Interface(), h.inmemoryExpiration); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 func (h hybridCachingSource) SetValue(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	if err := h.inmemoryCachingSource.SetValue(key, value); err != nil {
+	inmemoryExpiration := h.inmemoryExpiration
+	if expiration > 0 && expiration < inmemoryExpiration {
+		inmemoryExpiration = expiration
+	}
+
+	if err := h.inmemoryCachingSource.SetValueWithExpiration(key, value, inmemoryExpiration); err != nil {
+		return err
+	}
+	return h.distributedCachingSource.SetValue(ctx, distributedKeyPrefix+key, &distributedEntry{Cached: true, Value: value}, expiration)
+}
+
+func (h hybridCachingSource) DeleteValueByKey(ctx context.Context, key string) error {
+	h.inmemoryCachingSource.DeleteValueByKey(key)
+	if err := h.distributedCachingSource.DeleteValueByKey(ctx, distributedKeyPrefix+key); err != nil {
 		return err
-	} else {
-		if err = h.distributedCachingSource.SetValue(ctx, key, value, expiration); err != nil {
-			return err
-		}
-		return nil
 	}
+	// The unversioned value could still be read by processes of the previous version.
+	return h.distributedCachingSource.DeleteValueByKey(ctx, key)
 }
 
-func New(inmemoryCacheSource in.InMemoryCachingSource, distributedCacheSource ds.DistributedCachingSource) interfaces.HybridCachingSource {
-	return &hybridCachingSource{
+func New(inmemoryCacheSource in.InMemoryCachingSource, distributedCacheSource ds.DistributedCachingSource, opts ...Option) interfaces.HybridCachingSource {
+	h := &hybridCachingSource{
 		inmemoryCachingSource:    inmemoryCacheSource,
 		distributedCachingSource: distributedCacheSource,
+		inmemoryExpiration:       time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(h)
 	}
+
+	return h
 }
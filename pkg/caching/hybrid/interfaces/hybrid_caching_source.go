@@ -5,7 +5,10 @@ import (
 	"time"
 )
 
-type HybridCachingSource interface{
+type HybridCachingSource interface {
 	GetOrSetValueByKey(ctx context.Context, key string, expiration time.Duration, callbackFunc func() (interface{}, error)) (interface{}, error)
+	// GetValueByKey decodes the cached value into result, a pointer to a value of its type, and returns false when it is not cached.
+	GetValueByKey(ctx context.Context, key string, result interface{}) (bool, error)
 	SetValue(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	DeleteValueByKey(ctx context.Context, key string) error
 }
@@ -3,6 +3,7 @@ package inmemory
 import (
 	"github.com/dgraph-io/ristretto"
 	"github.com/ereb-or-od/kenobi/pkg/caching/inmemory/interfaces"
+	"time"
 )
 
 type inmemoryCachingSource struct {
@@ -26,6 +27,11 @@ func (i inmemoryCachingSource) SetValue(key string, value interface{}) error {
 	return nil
 }
 
+func (i inmemoryCachingSource) SetValueWithExpiration(key string, value interface{}, expiration time.Duration) error {
+	i.cache.SetWithTTL(key, value, 1, expiration)
+	return nil
+}
+
 func New() (interfaces.InMemoryCachingSource, error) {
 	cache, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,     // number of keys to track frequency of (10M).
@@ -1,9 +1,10 @@
 package interfaces
 
+import "time"
+
 type InMemoryCachingSource interface {
 	GetValueByKey(key string) interface{}
 	SetValue(key string, value interface{}) error
+	SetValueWithExpiration(key string, value interface{}, expiration time.Duration) error
 	DeleteValueByKey(key string)
 }
-
-
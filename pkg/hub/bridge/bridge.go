@@ -0,0 +1,228 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub"
+	"github.com/ereb-or-od/kenobi/pkg/logging"
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+	"github.com/google/uuid"
+)
+
+// OriginField is the message field holding the id of the bridge which forwarded the message.
+// Messages with an origin are never forwarded again, which prevents loops between instances.
+const OriginField = "hub.origin"
+
+// Transport carries encoded hub messages between instances.
+type Transport interface {
+	// Publish sends the encoded message published on topic to the other instances.
+	Publish(ctx context.Context, topic string, data []byte) error
+	// Subscribe starts receiving the messages sent by the other instances on the given topics.
+	// The channel is closed when the context is canceled or the transport is closed.
+	Subscribe(ctx context.Context, topics []string) (<-chan []byte, error)
+	// Close releases the transport resources.
+	Close() error
+}
+
+// Option could be used to configure Bridge
+type Option func(b *Bridge)
+
+// Bridge forwards the messages published on selected topics of a local hub to a Transport
+// and publishes the messages received from the other instances on the local hub.
+type Bridge struct {
+	hub        *hub.Hub
+	transport  Transport
+	marshaller interfaces.Marshaller
+	logger     logger.Logger
+
+	originID string
+	topics   []string
+	cap      int
+}
+
+// New returns Bridge or a configuration error.
+func New(h *hub.Hub, transport Transport, marshaller interfaces.Marshaller, opts ...Option) (*Bridge, error) {
+	b := &Bridge{
+		hub:        h,
+		transport:  transport,
+		marshaller: marshaller,
+		originID:   uuid.NewString(),
+		cap:        100,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.hub == nil {
+		return nil, fmt.Errorf("hub must be not nil")
+	}
+
+	if b.transport == nil {
+		return nil, fmt.Errorf("transport must be not nil")
+	}
+
+	if b.marshaller == nil {
+		return nil, fmt.Errorf("marshaller must be not nil")
+	}
+
+	if len(b.topics) == 0 {
+		return nil, fmt.Errorf("at least one topic must be set")
+	}
+
+	if b.originID == "" {
+		return nil, fmt.Errorf("origin id must be not empty")
+	}
+
+	if b.logger == nil {
+		defaultLogger, err := logging.New()
+		if err != nil {
+			return nil, err
+		}
+		b.logger = defaultLogger
+	}
+
+	return b, nil
+}
+
+// WithTopics configure the topics forwarded to and received from the other instances. Wildcards are allowed.
+func WithTopics(topics ...string) Option {
+	return func(b *Bridge) {
+		b.topics = append(b.topics, topics...)
+	}
+}
+
+// WithOriginID configure the id identifying this instance. Default: a random uuid.
+func WithOriginID(id string) Option {
+	return func(b *Bridge) {
+		b.originID = id
+	}
+}
+
+// WithCapacity configure the capacity of the local subscription. Default: 100.
+func WithCapacity(cap int) Option {
+	return func(b *Bridge) {
+		b.cap = cap
+	}
+}
+
+// WithLogger configure the logger used by Bridge
+func WithLogger(l logger.Logger) Option {
+	return func(b *Bridge) {
+		b.logger = l
+	}
+}
+
+// OriginID returns the id identifying this instance.
+func (b *Bridge) OriginID() string {
+	return b.originID
+}
+
+// Run forwards messages in both directions until the context is canceled or the transport stops receiving.
+// The local messages are forwarded from their own goroutine, so the messages received from the other instances
+// are published on the hub even while the local subscription is full.
+func (b *Bridge) Run(ctx context.Context) error {
+	receiveCh, err := b.transport.Subscribe(ctx, b.topics)
+	if err != nil {
+		return err
+	}
+
+	sub := b.hub.Subscribe(b.cap, b.topics...)
+
+	forwardDoneCh := make(chan struct{})
+	go func() {
+		defer close(forwardDoneCh)
+		b.forwardAll(ctx, sub)
+	}()
+
+	defer func() {
+		b.hub.Unsubscribe(sub)
+		<-forwardDoneCh
+	}()
+
+	b.logger.Debug("[DEBUG] hub bridge started")
+	defer b.logger.Debug("[DEBUG] hub bridge stopped")
+
+	for {
+		select {
+		case data, ok := <-receiveCh:
+			if !ok {
+				return fmt.Errorf("transport stopped receiving")
+			}
+			b.receive(data)
+		case <-forwardDoneCh:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// forwardAll drains the local subscription until it is closed or the context is canceled.
+func (b *Bridge) forwardAll(ctx context.Context, sub hub.Subscription) {
+	for {
+		select {
+		case msg, ok := <-sub.Receiver:
+			if !ok {
+				return
+			}
+			b.forward(ctx, msg)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Bridge) forward(ctx context.Context, msg hub.Message) {
+	if _, ok := msg.Fields[OriginField]; ok {
+		return
+	}
+
+	fields := make(hub.Fields, len(msg.Fields)+1)
+	for k, v := range msg.Fields {
+		fields[k] = v
+	}
+	fields[OriginField] = b.originID
+	msg.Fields = fields
+
+	data, err := b.marshaller.Marshall(msg)
+	if err != nil {
+		b.logger.Error("[ERROR] hub bridge: marshall", err, map[string]interface{}{"topic": msg.Topic()})
+		return
+	}
+
+	if err = b.transport.Publish(ctx, msg.Topic(), data); err != nil {
+		b.logger.Error("[ERROR] hub bridge: publish", err, map[string]interface{}{"topic": msg.Topic()})
+	}
+}
+
+func (b *Bridge) receive(data []byte) {
+	var msg hub.Message
+	if err := b.marshaller.Unmarshall(data, &msg); err != nil {
+		b.logger.Error("[ERROR] hub bridge: unmarshall", err)
+		return
+	}
+
+	if origin, _ := msg.Fields[OriginField].(string); origin == "" || origin == b.originID {
+		return
+	}
+
+	if !b.selected(msg.Topic()) {
+		return
+	}
+
+	// The sequence is local to every hub, the message gets a new one when published here.
+	msg.Sequence = 0
+	b.hub.Publish(msg)
+}
+
+func (b *Bridge) selected(topic string) bool {
+	for _, pattern := range b.topics {
+		if hub.Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
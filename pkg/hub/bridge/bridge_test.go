@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub"
+	"github.com/ereb-or-od/kenobi/pkg/hub/internal/hubtest"
+)
+
+func TestBridge_ForwardsSelectedTopicsWithoutLoops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	network := NewLocalNetwork()
+	first, second := hub.New(), hub.New()
+
+	for _, h := range []*hub.Hub{first, second} {
+		b, err := New(h, network.Transport(), hubtest.Marshaller{}, WithTopics("orders.#"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go b.Run(ctx)
+	}
+
+	// Let both bridges subscribe before publishing.
+	time.Sleep(50 * time.Millisecond)
+
+	sub := second.Subscribe(10, "#")
+	first.Publish(hub.Message{Name: "orders.created", Body: []byte("1")})
+	first.Publish(hub.Message{Name: "payments.created", Body: []byte("2")})
+
+	select {
+	case msg := <-sub.Receiver:
+		if msg.Name != "orders.created" || string(msg.Body) != "1" {
+			t.Fatalf("unexpected message %s %s", msg.Name, msg.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not bridged")
+	}
+
+	select {
+	case msg := <-sub.Receiver:
+		t.Fatalf("unexpected message %s", msg.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBridge_ReceivesMoreThanCapacityWithoutBlocking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const count = 100
+
+	// Every remote message is already waiting when the bridge starts, so it keeps receiving.
+	transport := &queuedTransport{ch: make(chan []byte, count)}
+	for i := 0; i < count; i++ {
+		data, err := hubtest.Marshaller{}.Marshall(hub.Message{
+			Name:   "orders.created",
+			Fields: hub.Fields{OriginField: "remote"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.ch <- data
+	}
+
+	local := hub.New()
+	received := local.Subscribe(count, "orders.#")
+
+	// The received messages also land in the capacity 10 subscription of the bridge.
+	b, err := New(local, transport, hubtest.Marshaller{}, WithTopics("orders.#"), WithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Run(ctx)
+
+	for i := 0; i < count; i++ {
+		select {
+		case <-received.Receiver:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d messages received", i, count)
+		}
+	}
+}
+
+type queuedTransport struct {
+	ch chan []byte
+}
+
+func (t *queuedTransport) Publish(_ context.Context, _ string, _ []byte) error {
+	return nil
+}
+
+func (t *queuedTransport) Subscribe(_ context.Context, _ []string) (<-chan []byte, error) {
+	return t.ch, nil
+}
+
+func (t *queuedTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,176 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// LocalNetwork connects LocalTransports in the same process.
+// It could be used in tests to bridge several hubs without a broker.
+type LocalNetwork struct {
+	mu         sync.RWMutex
+	transports map[*LocalTransport]struct{}
+}
+
+// LocalTransport is a Transport delivering messages to every transport of its LocalNetwork, itself included.
+// Deliveries are queued without limit so publishing never blocks.
+type LocalTransport struct {
+	network *LocalNetwork
+
+	mu        sync.Mutex
+	receivers map[*localReceiver]struct{}
+	closed    bool
+}
+
+type localReceiver struct {
+	mu     sync.Mutex
+	queue  [][]byte
+	wakeCh chan struct{}
+	doneCh chan struct{}
+	outCh  chan []byte
+	once   sync.Once
+}
+
+// NewLocalNetwork returns an empty LocalNetwork.
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{
+		transports: make(map[*LocalTransport]struct{}),
+	}
+}
+
+// Transport returns a new LocalTransport connected to the network.
+func (n *LocalNetwork) Transport() *LocalTransport {
+	t := &LocalTransport{
+		network:   n,
+		receivers: make(map[*localReceiver]struct{}),
+	}
+
+	n.mu.Lock()
+	n.transports[t] = struct{}{}
+	n.mu.Unlock()
+
+	return t
+}
+
+func (t *LocalTransport) Publish(_ context.Context, _ string, data []byte) error {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+
+	if closed {
+		return fmt.Errorf("transport closed")
+	}
+
+	t.network.mu.RLock()
+	defer t.network.mu.RUnlock()
+
+	for transport := range t.network.transports {
+		transport.deliver(data)
+	}
+
+	return nil
+}
+
+func (t *LocalTransport) Subscribe(ctx context.Context, _ []string) (<-chan []byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("transport closed")
+	}
+
+	r := &localReceiver{
+		wakeCh: make(chan struct{}, 1),
+		doneCh: make(chan struct{}),
+		outCh:  make(chan []byte),
+	}
+	t.receivers[r] = struct{}{}
+
+	go r.pump()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.doneCh:
+		}
+		t.remove(r)
+	}()
+
+	return r.outCh, nil
+}
+
+func (t *LocalTransport) Close() error {
+	t.network.mu.Lock()
+	delete(t.network.transports, t)
+	t.network.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for r := range t.receivers {
+		r.close()
+		delete(t.receivers, r)
+	}
+
+	return nil
+}
+
+func (t *LocalTransport) deliver(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for r := range t.receivers {
+		r.enqueue(data)
+	}
+}
+
+func (t *LocalTransport) remove(r *localReceiver) {
+	t.mu.Lock()
+	delete(t.receivers, r)
+	t.mu.Unlock()
+
+	r.close()
+}
+
+func (r *localReceiver) enqueue(data []byte) {
+	r.mu.Lock()
+	r.queue = append(r.queue, data)
+	r.mu.Unlock()
+
+	select {
+	case r.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *localReceiver) pump() {
+	defer close(r.outCh)
+
+	for {
+		select {
+		case <-r.wakeCh:
+		case <-r.doneCh:
+			return
+		}
+
+		r.mu.Lock()
+		queue := r.queue
+		r.queue = nil
+		r.mu.Unlock()
+
+		for _, data := range queue {
+			select {
+			case r.outCh <- data:
+			case <-r.doneCh:
+				return
+			}
+		}
+	}
+}
+
+func (r *localReceiver) close() {
+	r.once.Do(func() {
+		close(r.doneCh)
+	})
+}
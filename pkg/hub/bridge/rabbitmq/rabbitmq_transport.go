@@ -0,0 +1,97 @@
+package rabbitmq
+
+import (
+	"context"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub/bridge"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+// DefaultExchange is the topic exchange used when no exchange name is given.
+const DefaultExchange = "hub"
+
+type rabbitmqTransport struct {
+	dialer    *rabbitmq.Dialer
+	exchange  string
+	publisher *publisher.Publisher
+}
+
+// New returns a bridge.Transport publishing hub messages on a RabbitMQ topic exchange with the topic as routing key.
+// The exchange is declared durable on every reconnect. Every instance consumes from its own temporary queue.
+func New(d *rabbitmq.Dialer, exchange string) (bridge.Transport, error) {
+	if exchange == "" {
+		exchange = DefaultExchange
+	}
+
+	p, err := d.Publisher(publisher.WithInitFunc(d.PublisherInitFunc(declareExchange(exchange))))
+	if err != nil {
+		return nil, err
+	}
+
+	return &rabbitmqTransport{
+		dialer:    d,
+		exchange:  exchange,
+		publisher: p,
+	}, nil
+}
+
+func (r *rabbitmqTransport) Publish(ctx context.Context, topic string, data []byte) error {
+	return r.publisher.Publish(publisher.Message{
+		Context:  ctx,
+		Exchange: r.exchange,
+		Key:      topic,
+		Publishing: amqp.Publishing{
+			ContentType: "application/json",
+			Body:        data,
+		},
+	})
+}
+
+func (r *rabbitmqTransport) Subscribe(ctx context.Context, topics []string) (<-chan []byte, error) {
+	// The consumer binds a single routing key, several topics are filtered by the bridge.
+	routingKey := "#"
+	if len(topics) == 1 {
+		routingKey = topics[0]
+	}
+
+	receiveCh := make(chan []byte)
+	c, err := r.dialer.Consumer(
+		consumer.WithContext(ctx),
+		consumer.WithExchange(r.exchange, routingKey),
+		consumer.WithConsumeArgs("", true, true, false, false, nil),
+		consumer.WithInitFunc(r.dialer.ConsumerInitFunc(declareExchange(r.exchange))),
+		consumer.WithHandler(consumer.HandlerFunc(func(ctx context.Context, msg amqp.Delivery) interface{} {
+			select {
+			case receiveCh <- msg.Body:
+			case <-ctx.Done():
+			}
+			return nil
+		})),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-c.NotifyClosed()
+		close(receiveCh)
+	}()
+
+	return receiveCh, nil
+}
+
+func (r *rabbitmqTransport) Close() error {
+	r.publisher.Close()
+	<-r.publisher.NotifyClosed()
+	return nil
+}
+
+// declareExchange declares the durable topic exchange on the channels opened by the Dialer.
+func declareExchange(exchange string) func(ch rabbitmq.AMQPChannel) error {
+	return func(ch rabbitmq.AMQPChannel) error {
+		return ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	}
+}
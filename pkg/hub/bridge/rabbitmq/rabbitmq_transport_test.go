@@ -0,0 +1,56 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/amqptest"
+)
+
+func TestTransport_DeliversPublishedTopicsThroughTheExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := amqptest.NewBroker()
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(amqptest.DialerInitFunc),
+		rabbitmq.WithRetryPeriod(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	transport, err := New(d, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	receiveCh, err := transport.Subscribe(ctx, []string{"orders.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The binding is declared once the consumer is ready, publish until the message is routed.
+	for {
+		if err = transport.Publish(ctx, "orders.created", []byte(`{"id":1}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case data := <-receiveCh:
+			if string(data) != `{"id":1}` {
+				t.Fatalf("unexpected data %s", data)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("message was not received")
+		}
+	}
+}
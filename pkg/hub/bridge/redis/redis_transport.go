@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub/bridge"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// DefaultChannelPrefix is prepended to the hub topic to build the Redis channel name.
+const DefaultChannelPrefix = "hub:"
+
+type redisTransport struct {
+	client goredis.UniversalClient
+	prefix string
+}
+
+// New returns a bridge.Transport publishing hub messages on Redis pub/sub channels named prefix + topic.
+// Redis patterns can't express the hub wildcards, so every bridged channel is received and the bridge filters the topics.
+func New(client goredis.UniversalClient, prefix string) bridge.Transport {
+	if prefix == "" {
+		prefix = DefaultChannelPrefix
+	}
+
+	return &redisTransport{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (r redisTransport) Publish(ctx context.Context, topic string, data []byte) error {
+	return r.client.Publish(ctx, r.prefix+topic, data).Err()
+}
+
+func (r redisTransport) Subscribe(ctx context.Context, _ []string) (<-chan []byte, error) {
+	pubSub := r.client.PSubscribe(ctx, r.prefix+"*")
+	if _, err := pubSub.Receive(ctx); err != nil {
+		_ = pubSub.Close()
+		return nil, fmt.Errorf("redis subscribe: %w", err)
+	}
+
+	receiveCh := make(chan []byte)
+	go func() {
+		defer close(receiveCh)
+		defer pubSub.Close()
+
+		messageCh := pubSub.Channel()
+		for {
+			select {
+			case msg, ok := <-messageCh:
+				if !ok {
+					return
+				}
+
+				select {
+				case receiveCh <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return receiveCh, nil
+}
+
+// Close does nothing, the client is owned by the caller.
+func (r redisTransport) Close() error {
+	return nil
+}
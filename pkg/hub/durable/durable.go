@@ -0,0 +1,308 @@
+package durable
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub"
+	"github.com/ereb-or-od/kenobi/pkg/logging"
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+)
+
+const offsetFile = "offset"
+
+type (
+	// Option could be used to configure Subscription
+	Option func(s *Subscription)
+
+	// Delivery is a message read from the log with its offset, used to acknowledge it.
+	Delivery struct {
+		Offset  uint64
+		Message hub.Message
+	}
+
+	// Subscription is a named hub subscription persisted to an append-only log in a local directory.
+	// Every message published on its topics is written to the log and delivered in order on Receiver.
+	// The messages not acknowledged before a restart are delivered again when the subscription is opened.
+	// The records of a corrupted or truncated segment after the bad one are skipped and logged.
+	Subscription struct {
+		hub        *hub.Hub
+		marshaller interfaces.Marshaller
+		logger     logger.Logger
+
+		name        string
+		dir         string
+		topics      []string
+		cap         int
+		segmentSize int64
+		sync        bool
+
+		log          *segmentLog
+		subscription hub.Subscription
+		deliveries   chan Delivery
+
+		mu        sync.Mutex
+		acked     uint64
+		delivered uint64
+
+		doneCh    chan struct{}
+		writerWg  sync.WaitGroup
+		readerWg  sync.WaitGroup
+		closeOnce sync.Once
+	}
+)
+
+// Subscribe opens the log of the named subscription in dir and subscribes it on the hub.
+func Subscribe(h *hub.Hub, dir, name string, marshaller interfaces.Marshaller, opts ...Option) (*Subscription, error) {
+	s := &Subscription{
+		hub:         h,
+		marshaller:  marshaller,
+		name:        name,
+		cap:         100,
+		segmentSize: 64 << 20,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.hub == nil {
+		return nil, fmt.Errorf("hub must be not nil")
+	}
+
+	if s.marshaller == nil {
+		return nil, fmt.Errorf("marshaller must be not nil")
+	}
+
+	if s.name == "" || strings.ContainsAny(s.name, `/\`) {
+		return nil, fmt.Errorf("name must be not empty and without path separators")
+	}
+
+	if len(s.topics) == 0 {
+		return nil, fmt.Errorf("at least one topic must be set")
+	}
+
+	if s.segmentSize <= 0 {
+		return nil, fmt.Errorf("segment size must be positive")
+	}
+
+	if s.logger == nil {
+		defaultLogger, err := logging.New()
+		if err != nil {
+			return nil, err
+		}
+		s.logger = defaultLogger
+	}
+
+	s.dir = filepath.Join(dir, s.name)
+
+	var err error
+	if s.log, err = openLog(s.dir, s.segmentSize, s.sync); err != nil {
+		return nil, err
+	}
+
+	if s.acked, err = s.readOffset(); err != nil {
+		s.log.close()
+		return nil, err
+	}
+	s.delivered = s.acked
+
+	s.deliveries = make(chan Delivery)
+	s.doneCh = make(chan struct{})
+	s.subscription = s.hub.Subscribe(s.cap, s.topics...)
+
+	s.writerWg.Add(1)
+	go s.write()
+
+	s.readerWg.Add(1)
+	go s.read()
+
+	return s, nil
+}
+
+// WithTopics configure the topics written to the log. Wildcards are allowed.
+func WithTopics(topics ...string) Option {
+	return func(s *Subscription) {
+		s.topics = append(s.topics, topics...)
+	}
+}
+
+// WithCapacity configure the capacity of the hub subscription feeding the log. Default: 100.
+func WithCapacity(cap int) Option {
+	return func(s *Subscription) {
+		s.cap = cap
+	}
+}
+
+// WithSegmentSize configure the size in bytes after which a new segment file is started. Default: 64MB.
+func WithSegmentSize(size int64) Option {
+	return func(s *Subscription) {
+		s.segmentSize = size
+	}
+}
+
+// WithSync configure if every write is synced to the disk. Default: false.
+func WithSync(sync bool) Option {
+	return func(s *Subscription) {
+		s.sync = sync
+	}
+}
+
+// WithLogger configure the logger used by Subscription
+func WithLogger(l logger.Logger) Option {
+	return func(s *Subscription) {
+		s.logger = l
+	}
+}
+
+// Receiver returns the channel of the deliveries. It is closed by Close.
+func (s *Subscription) Receiver() <-chan Delivery {
+	return s.deliveries
+}
+
+// Ack acknowledges the delivery with the offset and all the previous ones.
+// Segments holding only acknowledged messages are removed.
+func (s *Subscription) Ack(offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset <= s.acked {
+		return nil
+	}
+
+	if offset > s.delivered {
+		return fmt.Errorf("durable: offset %d was not delivered", offset)
+	}
+
+	if err := s.writeOffset(offset); err != nil {
+		return err
+	}
+	s.acked = offset
+
+	return s.log.compact(offset + 1)
+}
+
+// Acked returns the last acknowledged offset.
+func (s *Subscription) Acked() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acked
+}
+
+// Close unsubscribes from the hub, writes the pending messages to the log and closes it.
+// The messages not acknowledged are delivered again by the next Subscribe with the same name.
+func (s *Subscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.hub.Unsubscribe(s.subscription)
+		s.writerWg.Wait()
+
+		close(s.doneCh)
+		s.readerWg.Wait()
+		close(s.deliveries)
+
+		err = s.log.close()
+	})
+	return err
+}
+
+func (s *Subscription) write() {
+	defer s.writerWg.Done()
+
+	for msg := range s.subscription.Receiver {
+		data, err := s.marshaller.Marshall(msg)
+		if err != nil {
+			s.logger.Error("[ERROR] durable subscription: marshall", err, map[string]interface{}{"name": s.name, "topic": msg.Topic()})
+			continue
+		}
+
+		if _, err = s.log.append(data); err != nil {
+			s.logger.Error("[ERROR] durable subscription: append", err, map[string]interface{}{"name": s.name, "topic": msg.Topic()})
+		}
+	}
+}
+
+func (s *Subscription) read() {
+	defer s.readerWg.Done()
+
+	reader := s.log.newReader(s.acked + 1)
+	defer reader.close()
+
+	for {
+		waitCh := s.log.wait()
+
+		offset, data, ok, err := reader.read()
+		if err != nil {
+			s.logger.Error("[ERROR] durable subscription: read", err, map[string]interface{}{"name": s.name})
+			if errors.Is(err, errSkipped) {
+				continue
+			}
+
+			// The log could not be read, try again on the next append.
+			select {
+			case <-waitCh:
+				continue
+			case <-s.doneCh:
+				return
+			}
+		}
+
+		if !ok {
+			select {
+			case <-waitCh:
+				continue
+			case <-s.doneCh:
+				return
+			}
+		}
+
+		var msg hub.Message
+		if err = s.marshaller.Unmarshall(data, &msg); err != nil {
+			s.logger.Error("[ERROR] durable subscription: unmarshall", err, map[string]interface{}{"name": s.name, "offset": offset})
+			continue
+		}
+
+		// Marked as delivered before sending so the receiver could acknowledge it right away.
+		s.mu.Lock()
+		s.delivered = offset
+		s.mu.Unlock()
+
+		select {
+		case s.deliveries <- Delivery{Offset: offset, Message: msg}:
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+func (s *Subscription) readOffset() (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, offsetFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeOffset replaces the offset file atomically.
+func (s *Subscription) writeOffset(offset uint64) error {
+	path := filepath.Join(s.dir, offsetFile)
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatUint(offset, 10)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
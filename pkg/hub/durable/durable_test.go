@@ -0,0 +1,185 @@
+package durable
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub"
+	"github.com/ereb-or-od/kenobi/pkg/hub/internal/hubtest"
+)
+
+func receive(t *testing.T, s *Subscription) Delivery {
+	t.Helper()
+
+	select {
+	case d := <-s.Receiver():
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	return Delivery{}
+}
+
+func segments(t *testing.T, dir string) int {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func TestSubscription_RedeliversUnackedMessagesAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := hub.New()
+	opts := []Option{WithTopics("orders.#"), WithSegmentSize(300)}
+
+	s, err := Subscribe(h, dir, "orders", hubtest.Marshaller{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		h.Publish(hub.Message{Name: "orders.created", Body: []byte(strconv.Itoa(i))})
+	}
+
+	for i := 1; i <= 6; i++ {
+		d := receive(t, s)
+		if d.Offset != uint64(i) || string(d.Message.Body) != strconv.Itoa(i) {
+			t.Fatalf("unexpected delivery %d %s", d.Offset, d.Message.Body)
+		}
+	}
+
+	if err = s.Ack(7); err == nil {
+		t.Fatal("expected an error acknowledging an offset not delivered")
+	}
+
+	if segments(t, filepath.Join(dir, "orders")) < 3 {
+		t.Fatal("expected the log to be rotated")
+	}
+
+	if err = s.Ack(6); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Subscribe(h, dir, "orders", hubtest.Marshaller{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.Acked() != 6 {
+		t.Fatalf("expected acknowledged offset 6, got %d", s.Acked())
+	}
+
+	for i := 7; i <= 10; i++ {
+		d := receive(t, s)
+		if d.Offset != uint64(i) || string(d.Message.Body) != strconv.Itoa(i) {
+			t.Fatalf("unexpected delivery %d %s", d.Offset, d.Message.Body)
+		}
+	}
+
+	h.Publish(hub.Message{Name: "orders.created", Body: []byte("11")})
+	if d := receive(t, s); d.Offset != 11 {
+		t.Fatalf("unexpected offset %d", d.Offset)
+	}
+
+	if err = s.Ack(11); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := segments(t, filepath.Join(dir, "orders")); n != 1 {
+		t.Fatalf("expected the acknowledged segments to be removed, got %d", n)
+	}
+}
+
+func TestSubscription_SkipsTheRestOfATruncatedSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := hub.New()
+	opts := []Option{WithTopics("orders.#"), WithSegmentSize(300)}
+
+	s, err := Subscribe(h, dir, "orders", hubtest.Marshaller{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		h.Publish(hub.Message{Name: "orders.created", Body: []byte(strconv.Itoa(i))})
+	}
+	for i := 1; i <= 10; i++ {
+		receive(t, s)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "orders", "*"+segmentExt))
+	if err != nil || len(matches) < 3 {
+		t.Fatalf("expected at least 3 segments, got %v %v", matches, err)
+	}
+
+	// The last record of the first segment loses its end.
+	info, err := os.Stat(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(matches[0], info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	second, err := strconv.ParseUint(filepath.Base(matches[1])[:20], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Subscribe(h, dir, "orders", hubtest.Marshaller{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var offsets []uint64
+	for {
+		d := receive(t, s)
+		offsets = append(offsets, d.Offset)
+		if d.Offset == 10 {
+			break
+		}
+	}
+
+	// The records before the truncated one are delivered, then the reader goes on with the next segment.
+	var expected []uint64
+	for offset := uint64(1); offset <= 10; offset++ {
+		if offset != second-1 {
+			expected = append(expected, offset)
+		}
+	}
+	if fmt.Sprint(offsets) != fmt.Sprint(expected) {
+		t.Fatalf("delivered offsets %v, want %v", offsets, expected)
+	}
+
+	// New records are still delivered.
+	h.Publish(hub.Message{Name: "orders.created", Body: []byte("11")})
+	if d := receive(t, s); d.Offset != 11 {
+		t.Fatalf("unexpected offset %d", d.Offset)
+	}
+}
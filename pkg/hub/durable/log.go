@@ -0,0 +1,425 @@
+package durable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentExt = ".log"
+	// headerSize is the size of the record header: offset (8), data length (4) and data crc32 (4).
+	headerSize = 16
+)
+
+var (
+	errCorrupted = errors.New("durable: corrupted record")
+	// errSkipped wraps the errors of the records the reader skipped to carry on reading.
+	errSkipped = errors.New("skipped")
+)
+
+type (
+	// segmentLog is an append-only log of records split in segment files named by their first offset.
+	segmentLog struct {
+		dir         string
+		segmentSize int64
+		sync        bool
+
+		mu       sync.Mutex
+		segments []*segment
+		active   *os.File
+		next     uint64
+		notifyCh chan struct{}
+	}
+
+	segment struct {
+		base uint64
+		path string
+		size int64
+	}
+
+	// logReader reads the records of a segmentLog sequentially.
+	logReader struct {
+		log     *segmentLog
+		segment *segment
+		file    *os.File
+		reader  *bufio.Reader
+		pos     int64
+		next    uint64
+	}
+)
+
+// openLog opens the log in dir, an incomplete record at the end of the last segment is truncated.
+func openLog(dir string, segmentSize int64, sync bool) (*segmentLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &segmentLog{
+		dir:         dir,
+		segmentSize: segmentSize,
+		sync:        sync,
+		next:        1,
+		notifyCh:    make(chan struct{}),
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		l.segments = append(l.segments, &segment{
+			base: base,
+			path: filepath.Join(dir, name),
+			size: entry.Size(),
+		})
+	}
+
+	sort.Slice(l.segments, func(i, j int) bool {
+		return l.segments[i].base < l.segments[j].base
+	})
+
+	if len(l.segments) == 0 {
+		if err = l.rotate(); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
+	last := l.segments[len(l.segments)-1]
+	if err = l.recover(last); err != nil {
+		return nil, err
+	}
+
+	l.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// recover scans the segment to find the next offset and truncates the incomplete records.
+func (l *segmentLog) recover(s *segment) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l.next = s.base
+	r := bufio.NewReader(f)
+
+	var pos int64
+	for {
+		offset, data, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		pos += int64(headerSize + len(data))
+		l.next = offset + 1
+	}
+
+	if pos != s.size {
+		if err = os.Truncate(s.path, pos); err != nil {
+			return err
+		}
+		s.size = pos
+	}
+
+	return nil
+}
+
+// rotate closes the active segment and starts a new one at the next offset. The lock must be held.
+func (l *segmentLog) rotate() error {
+	if l.active != nil {
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	s := &segment{
+		base: l.next,
+		path: filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.next, segmentExt)),
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.active = f
+	l.segments = append(l.segments, s)
+
+	return nil
+}
+
+// append writes the data as a new record and returns its offset.
+func (l *segmentLog) append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return 0, fmt.Errorf("durable: log closed")
+	}
+
+	current := l.segments[len(l.segments)-1]
+	if current.size > 0 && current.size+int64(headerSize+len(data)) > l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+		current = l.segments[len(l.segments)-1]
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint64(record[0:8], l.next)
+	binary.BigEndian.PutUint32(record[8:12], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[12:16], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+
+	if _, err := l.active.Write(record); err != nil {
+		return 0, err
+	}
+
+	if l.sync {
+		if err := l.active.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	offset := l.next
+	l.next++
+	current.size += int64(len(record))
+
+	close(l.notifyCh)
+	l.notifyCh = make(chan struct{})
+
+	return offset, nil
+}
+
+// nextOffset returns the offset of the next appended record.
+func (l *segmentLog) nextOffset() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next
+}
+
+// wait returns a channel closed on the next append.
+func (l *segmentLog) wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.notifyCh
+}
+
+// compact removes the segments holding only records before offset. The active segment is always kept.
+func (l *segmentLog) compact(offset uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.segments) > 1 && l.segments[1].base <= offset {
+		if err := os.Remove(l.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+
+	return nil
+}
+
+// segmentAfter returns the segment holding the offset, or the first one after the given segment.
+func (l *segmentLog) segmentAfter(offset uint64, previous *segment) *segment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if previous != nil {
+		for _, s := range l.segments {
+			if s.base > previous.base {
+				return s
+			}
+		}
+		return nil
+	}
+
+	var found *segment
+	for _, s := range l.segments {
+		if s.base > offset && found != nil {
+			break
+		}
+		found = s
+	}
+
+	return found
+}
+
+// size returns the current size of the segment.
+func (l *segmentLog) size(s *segment) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return s.size
+}
+
+// isActive tells if the segment is the last one.
+func (l *segmentLog) isActive(s *segment) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.segments[len(l.segments)-1] == s
+}
+
+func (l *segmentLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+
+	err := l.active.Close()
+	l.active = nil
+
+	return err
+}
+
+// newReader returns a reader starting at offset.
+func (l *segmentLog) newReader(offset uint64) *logReader {
+	return &logReader{
+		log:  l,
+		next: offset,
+	}
+}
+
+// read returns the next record, ok is false when the reader reached the end of the log.
+func (r *logReader) read() (offset uint64, data []byte, ok bool, err error) {
+	for {
+		if r.segment == nil {
+			s := r.log.segmentAfter(r.next, nil)
+			if s == nil {
+				return 0, nil, false, nil
+			}
+			if err = r.open(s); err != nil {
+				return 0, nil, false, err
+			}
+		}
+
+		if r.pos >= r.log.size(r.segment) {
+			if r.log.isActive(r.segment) {
+				return 0, nil, false, nil
+			}
+
+			// Only records appended before the rotation are in the segment, move to the next one.
+			s := r.log.segmentAfter(r.next, r.segment)
+			if s == nil {
+				return 0, nil, false, nil
+			}
+			if err = r.open(s); err != nil {
+				return 0, nil, false, err
+			}
+			continue
+		}
+
+		offset, data, err = readRecord(r.reader)
+		if err != nil {
+			return 0, nil, false, r.skip(err)
+		}
+		r.pos += int64(headerSize + len(data))
+
+		if offset < r.next {
+			continue
+		}
+
+		r.next = offset + 1
+		return offset, data, true, nil
+	}
+}
+
+// skip moves past the rest of the segment holding a truncated or corrupted record, the record length can't be trusted.
+// The next segment is read from its first record, the active one from its current end.
+func (r *logReader) skip(cause error) error {
+	if !r.log.isActive(r.segment) {
+		if s := r.log.segmentAfter(r.next, r.segment); s != nil {
+			r.next = s.base
+			if err := r.open(s); err != nil {
+				return err
+			}
+			return fmt.Errorf("durable: %v, %w to offset %d", cause, errSkipped, r.next)
+		}
+	}
+
+	size := r.log.size(r.segment)
+	if err := r.open(r.segment); err != nil {
+		return err
+	}
+	if _, err := r.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	r.pos = size
+	r.next = r.log.nextOffset()
+
+	return fmt.Errorf("durable: %v, %w to offset %d", cause, errSkipped, r.next)
+}
+
+func (r *logReader) open(s *segment) error {
+	r.close()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+
+	r.segment = s
+	r.file = f
+	r.reader = bufio.NewReader(f)
+	r.pos = 0
+
+	return nil
+}
+
+func (r *logReader) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func readRecord(r io.Reader) (uint64, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	offset := binary.BigEndian.Uint64(header[0:8])
+	data := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[12:16]) {
+		return 0, nil, errCorrupted
+	}
+
+	return offset, data, nil
+}
@@ -1,44 +1,76 @@
 package hub
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 // AlertTopic is used to notify when a nonblocking subscriber loose one message
-// You can subscribe on this topic and log or send metrics.
+// You can subscribe on this topic and log or send metrics, the counters are also available with Hub.Stats.
 const AlertTopic = "hub.subscription.messageslost"
 
 type (
 	//Hub is a component that provides publish and subscribe capabilities for messages.
 	// Every message has a Name used to route them to subscribers and this can be used like RabbitMQ topics exchanges.
-	// Where every word is separated by dots `.` and you can use `*` as a wildcard.
+	// Where every word is separated by dots `.` and you can use `*` as a wildcard for exactly one word
+	// and `#` as a wildcard for zero or more words.
 	Hub struct {
-		matcher matcher
-		fields  Fields
+		matcher   matcher
+		fields    Fields
+		sequence  *uint64
+		retention *retention
+		stats     *stats
 	}
 )
 
 // New create and return a new empty hub.
 func New() *Hub {
 	return &Hub{
-		matcher: newCSTrieMatcher(),
-		fields:  Fields{},
+		matcher:   newCSTrieMatcher(),
+		fields:    Fields{},
+		sequence:  new(uint64),
+		retention: newRetention(),
+		stats:     newStats(),
 	}
 }
 
 // Publish will send an event to all the subscribers matching the event name.
+// The message is stamped with the next sequence number and, if not set, the current time.
 func (h *Hub) Publish(m Message) {
+	start := time.Now()
+
+	if m.Fields == nil {
+		m.Fields = Fields{}
+	}
+
 	for k, v := range h.fields {
 		m.Fields[k] = v
 	}
 
-	for _, sub := range h.matcher.Lookup(m.Topic()) {
+	m.Sequence = atomic.AddUint64(h.sequence, 1)
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+
+	h.retention.retain(m)
+
+	subs := h.matcher.Lookup(m.Topic())
+	for _, sub := range subs {
 		sub.Set(m)
 	}
+
+	h.stats.published(m.Topic(), len(subs), start)
 }
 
 // With creates a child Hub with the fields added to it.
 // When someone call Publish, this Fields will be added automatically into the message.
 func (h *Hub) With(f Fields) *Hub {
 	hub := Hub{
-		matcher: h.matcher,
-		fields:  Fields{},
+		matcher:   h.matcher,
+		fields:    Fields{},
+		sequence:  h.sequence,
+		retention: h.retention,
+		stats:     h.stats,
 	}
 	for k, v := range h.fields {
 		hub.fields[k] = v
@@ -55,20 +87,17 @@ func (h *Hub) With(f Fields) *Hub {
 // The cap param is used inside the subscriber and in this case used to create a channel.
 // cap(1) = unbuffered channel.
 func (h *Hub) Subscribe(cap int, topics ...string) Subscription {
-	return h.matcher.Subscribe(topics, newBlockingSubscriber(cap))
+	return h.subscribe(topics, func(_ alertFunc) subscriber {
+		return newBlockingSubscriber(cap)
+	})
 }
 
 // NonBlockingSubscribe create a nonblocking subscription to receive events for a given topic.
 // This subscriber will loose messages if the buffer reaches the max capability.
 func (h *Hub) NonBlockingSubscribe(cap int, topics ...string) Subscription {
-	return h.matcher.Subscribe(
-		topics,
-		newNonBlockingSubscriber(
-			cap,
-			alertFunc(func(missed int) {
-				h.alert(missed, topics)
-			}),
-		))
+	return h.subscribe(topics, func(alert alertFunc) subscriber {
+		return newNonBlockingSubscriber(cap, alert)
+	})
 }
 
 // Unsubscribe remove and close the Subscription.
@@ -0,0 +1,23 @@
+package hubtest
+
+import "encoding/json"
+
+// Marshaller uses encoding/json so the hub tests do not depend on the json-iterator build.
+type Marshaller struct{}
+
+func (Marshaller) Marshall(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (Marshaller) Unmarshall(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (Marshaller) MarshallString(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func (Marshaller) UnmarshallString(data string, v interface{}) error {
+	return json.Unmarshal([]byte(data), v)
+}
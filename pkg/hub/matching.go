@@ -1,13 +1,17 @@
 package hub
 
+import "strings"
+
 const (
-	delimiter = "."
-	wildcard  = "*"
+	delimiter     = "."
+	wildcard      = "*"
+	multiWildcard = "#"
 )
 
 type (
 	// Subscription represents a topic subscription.
 	Subscription struct {
+		ID         uint64
 		Topics     []string
 		Receiver   <-chan Message
 		subscriber subscriber
@@ -41,3 +45,46 @@ type matcher interface {
 
 	Subscriptions() []Subscription
 }
+
+// splitTopic splits the topic into words.
+// Consecutive multi wildcards are collapsed since `#.#` matches the same topics as `#`.
+func splitTopic(topic string) []string {
+	words := strings.Split(topic, delimiter)
+	result := words[:0]
+
+	for i, word := range words {
+		if word == multiWildcard && i > 0 && words[i-1] == multiWildcard {
+			continue
+		}
+		result = append(result, word)
+	}
+
+	return result
+}
+
+// matchWords reports whether the topic words match the pattern words.
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	if pattern[0] == multiWildcard {
+		for k := 0; k <= len(words); k++ {
+			if matchWords(pattern[1:], words[k:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(words) == 0 || (pattern[0] != wildcard && pattern[0] != words[0]) {
+		return false
+	}
+
+	return matchWords(pattern[1:], words[1:])
+}
+
+// Match reports whether the topic matches the pattern, using the same wildcards as the subscriptions.
+func Match(pattern, topic string) bool {
+	return matchWords(splitTopic(pattern), strings.Split(topic, delimiter))
+}
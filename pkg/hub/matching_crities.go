@@ -121,10 +121,10 @@ func (c *cNode) removed(word string, sub subscriber) *cNode {
 	return &cNode{branches: branches}
 }
 
-// getBranches returns the branches for the given word. There are two possible
-// branches: exact match and single wildcard.
-func (c *cNode) getBranches(word string) (*branch, *branch) {
-	return c.branches[word], c.branches[wildcard]
+// getBranches returns the branches for the given word. There are three possible
+// branches: exact match, single wildcard and multi wildcard.
+func (c *cNode) getBranches(word string) (*branch, *branch, *branch) {
+	return c.branches[word], c.branches[wildcard], c.branches[multiWildcard]
 }
 
 type branch struct {
@@ -186,7 +186,7 @@ func (c *csTrieMatcher) Subscribe(topics []string, sub subscriber) Subscription
 	)
 
 	for _, topic := range topics {
-		words := strings.Split(topic, delimiter)
+		words := splitTopic(topic)
 		if !c.iinsert(root, nil, words, sub) {
 			return c.Subscribe(topics, sub)
 		}
@@ -257,7 +257,7 @@ func (c *csTrieMatcher) Unsubscribe(sub Subscription) {
 	)
 
 	for _, topic := range sub.Topics {
-		words := strings.Split(topic, delimiter)
+		words := splitTopic(topic)
 		if !c.iremove(root, nil, nil, words, 0, sub.subscriber) {
 			c.Unsubscribe(sub)
 		}
@@ -353,8 +353,9 @@ func (c *csTrieMatcher) ilookup(i, parent *iNode, words []string) ([]subscriber,
 
 	switch {
 	case main.cNode != nil:
-		// Traverse exact-match branch and single-word-wildcard branch.
-		exact, singleWC := main.cNode.getBranches(words[0])
+		// Traverse exact-match branch, single-word-wildcard branch and
+		// multi-word-wildcard branch.
+		exact, singleWC, multiWC := main.cNode.getBranches(words[0])
 		subs := make(map[subscriber]struct{})
 
 		if exact != nil {
@@ -379,6 +380,29 @@ func (c *csTrieMatcher) ilookup(i, parent *iNode, words []string) ([]subscriber,
 			}
 		}
 
+		if multiWC != nil {
+			// The multi wildcard is the last word of the subscription, it
+			// matches all the remaining words.
+			for sub := range multiWC.subs {
+				subs[sub] = struct{}{}
+			}
+
+			if multiWC.iNode != nil {
+				// Otherwise the multi wildcard consumes zero or more words and
+				// the rest of the subscription must match the remaining ones.
+				for k := range words {
+					s, ok := c.ilookup(multiWC.iNode, i, words[k:])
+					if !ok {
+						return nil, false
+					}
+
+					for _, sub := range s {
+						subs[sub] = struct{}{}
+					}
+				}
+			}
+		}
+
 		s := make([]subscriber, len(subs))
 		i := 0
 
@@ -413,7 +437,41 @@ func (c *csTrieMatcher) bLookup(i *iNode, b *branch, words []string) ([]subscrib
 	}
 
 	// Retrieve the subscribers from the branch.
-	return b.subscribers(), true
+	subs := b.subscribers()
+	if b.iNode == nil {
+		return subs, true
+	}
+
+	// A multi wildcard right after the last word matches zero words.
+	s, ok := c.multiWildcardLookup(b.iNode, i)
+	if !ok {
+		return nil, false
+	}
+
+	return append(subs, s...), true
+}
+
+// multiWildcardLookup returns the Subscribers of the multi wildcard branch
+// below the given I-node. True is returned if the Subscribers were retrieved,
+// false if the operation needs to be retried.
+func (c *csTrieMatcher) multiWildcardLookup(i, parent *iNode) ([]subscriber, bool) {
+	mainPtr := (*unsafe.Pointer)(unsafe.Pointer(&i.main))
+	main := (*mainNode)(atomic.LoadPointer(mainPtr))
+
+	switch {
+	case main.cNode != nil:
+		br, ok := main.cNode.branches[multiWildcard]
+		if !ok {
+			return nil, true
+		}
+
+		return br.subscribers(), true
+	case main.tNode != nil:
+		clean(parent)
+		return nil, false
+	default:
+		panic("csTrie is in an invalid state")
+	}
 }
 
 // Subscriptions return all the subscriptions inside the cstrie.
@@ -0,0 +1,150 @@
+package hub
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCSTrieMatcher_Lookup_MultiWildcard(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"#", "a", true},
+		{"#", "a.b.c", true},
+		{"a.#", "a", true},
+		{"a.#", "a.b", true},
+		{"a.#", "a.b.c", true},
+		{"a.#", "b.a", false},
+		{"#.c", "c", true},
+		{"#.c", "a.b.c", true},
+		{"#.c", "a.b.c.d", false},
+		{"a.#.c", "a.c", true},
+		{"a.#.c", "a.b.b.c", true},
+		{"a.#.c", "a.b.b.d", false},
+		{"a.*.#", "a", false},
+		{"a.*.#", "a.b", true},
+		{"a.*.#", "a.b.c.d", true},
+		{"a.#.#.c", "a.b.c", true},
+		{"#.b.#", "a.b.c", true},
+		{"#.b.#", "b", true},
+		{"#.b.#", "a.c", false},
+	}
+
+	for _, tc := range cases {
+		m := newCSTrieMatcher()
+		sub := newBlockingSubscriber(0)
+		m.Subscribe([]string{tc.pattern}, sub)
+
+		got := len(m.Lookup(tc.topic)) == 1
+		if got != tc.match {
+			t.Errorf("pattern %q topic %q: expected match %v, got %v", tc.pattern, tc.topic, tc.match, got)
+		}
+
+		if got := matchWords(splitTopic(tc.pattern), splitTopic(tc.topic)); got != tc.match {
+			t.Errorf("matchWords pattern %q topic %q: expected match %v, got %v", tc.pattern, tc.topic, tc.match, got)
+		}
+	}
+}
+
+func TestCSTrieMatcher_Unsubscribe_MultiWildcard(t *testing.T) {
+	m := newCSTrieMatcher()
+	sub := newBlockingSubscriber(0)
+	subscription := m.Subscribe([]string{"a.#.#", "#.c"}, sub)
+
+	if len(m.Lookup("a.b.c")) != 1 {
+		t.Fatal("expected the subscriber to be found once")
+	}
+
+	m.Unsubscribe(subscription)
+
+	if len(m.Lookup("a.b.c")) != 0 {
+		t.Fatal("expected no subscribers after unsubscribe")
+	}
+
+	if len(m.Subscriptions()) != 0 {
+		t.Fatal("expected no subscriptions after unsubscribe")
+	}
+}
+
+// topic spreads the subscriptions over groups of 1000 to keep the C-nodes small.
+func topic(i int, event string) string {
+	return "orders." + strconv.Itoa(i/1000) + "." + strconv.Itoa(i%1000) + "." + event
+}
+
+// benchmarkLookup measures Lookup with the given number of subscriptions.
+// Baseline of the matcher before the # wildcard on the same benchmarks (median of 3, go test -bench Lookup_ -benchmem):
+//
+//	                             before                          after
+//	Exact_10000                  1644 ns/op  170 B/op  7 allocs  1514 ns/op  170 B/op  7 allocs
+//	Exact_50000                  1620 ns/op  170 B/op  7 allocs  1729 ns/op  170 B/op  7 allocs
+//	SingleWildcard_10000        91784 ns/op 35863 B/op 45 allocs 94603 ns/op 35863 B/op 45 allocs
+//	SingleWildcard_50000       106395 ns/op 35863 B/op 45 allocs 105315 ns/op 35863 B/op 45 allocs
+//
+// The MultiWildcard benchmarks have no baseline, # was matched literally before.
+func benchmarkLookup(b *testing.B, subscriptions int, pattern func(i int) string) {
+	m := newCSTrieMatcher()
+	for i := 0; i < subscriptions; i++ {
+		m.Subscribe([]string{pattern(i)}, newBlockingSubscriber(0))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Lookup(topic(i%subscriptions, "created"))
+	}
+}
+
+func BenchmarkCSTrieMatcher_Lookup_Exact_10000(b *testing.B) {
+	benchmarkLookup(b, 10000, func(i int) string {
+		return topic(i, "created")
+	})
+}
+
+func BenchmarkCSTrieMatcher_Lookup_Exact_50000(b *testing.B) {
+	benchmarkLookup(b, 50000, func(i int) string {
+		return topic(i, "created")
+	})
+}
+
+func BenchmarkCSTrieMatcher_Lookup_SingleWildcard_10000(b *testing.B) {
+	benchmarkLookup(b, 10000, func(i int) string {
+		if i%10 == 0 {
+			return "orders." + strconv.Itoa(i/1000) + ".*.created"
+		}
+		return topic(i, "created")
+	})
+}
+
+func BenchmarkCSTrieMatcher_Lookup_SingleWildcard_50000(b *testing.B) {
+	benchmarkLookup(b, 50000, func(i int) string {
+		if i%10 == 0 {
+			return "orders." + strconv.Itoa(i/1000) + ".*.created"
+		}
+		return topic(i, "created")
+	})
+}
+
+func BenchmarkCSTrieMatcher_Lookup_MultiWildcard_10000(b *testing.B) {
+	benchmarkLookup(b, 10000, func(i int) string {
+		switch i % 10 {
+		case 0:
+			return "orders." + strconv.Itoa(i/1000) + ".#"
+		case 1:
+			return "#." + strconv.Itoa(i%1000) + ".created"
+		}
+		return topic(i, "created")
+	})
+}
+
+func BenchmarkCSTrieMatcher_Lookup_MultiWildcard_50000(b *testing.B) {
+	benchmarkLookup(b, 50000, func(i int) string {
+		switch i % 10 {
+		case 0:
+			return "orders." + strconv.Itoa(i/1000) + ".#"
+		case 1:
+			return "#." + strconv.Itoa(i%1000) + ".created"
+		}
+		return topic(i, "created")
+	})
+}
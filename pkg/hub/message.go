@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 )
 
 type (
@@ -12,10 +13,13 @@ type (
 
 	// Message represent some message/event passed into the hub
 	// It also contain some helper functions to convert the fields to primitive types.
+	// Sequence and Timestamp are stamped by the hub when the message is published.
 	Message struct {
-		Name   string
-		Body   []byte
-		Fields Fields
+		Name      string
+		Body      []byte
+		Fields    Fields
+		Sequence  uint64
+		Timestamp time.Time
 	}
 )
 
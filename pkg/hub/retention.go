@@ -0,0 +1,325 @@
+package hub
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Replay selects the retained messages delivered to a new subscription before the live ones.
+	Replay struct {
+		last  int
+		since uint64
+		all   bool
+	}
+
+	// retention keeps the ring buffers configured with Hub.Retain.
+	// The buffers are subscribers of their own matcher, so a published message is retained
+	// by every buffer whose topic pattern matches the message name.
+	retention struct {
+		matcher matcher
+	}
+
+	// ringBuffer is a subscriber keeping the last size messages it received.
+	ringBuffer struct {
+		mu       sync.RWMutex
+		messages []Message
+		next     int
+		full     bool
+	}
+
+	// replayingSubscriber wraps a blocking subscriber and holds back live messages until the replay is done.
+	// At most limit messages are held back, Set blocks beyond it like a full blocking subscriber does.
+	// sendMu is held while a replayed message waits for the consumer, so Close never closes the channel meanwhile;
+	// mu is never held while waiting for the consumer.
+	replayingSubscriber struct {
+		*blockingSubscriber
+
+		mu         sync.Mutex
+		sendMu     sync.Mutex
+		drained    *sync.Cond
+		done       chan struct{}
+		onceDone   sync.Once
+		replaying  bool
+		closed     bool
+		limit      int
+		pending    []Message
+		pendingLen int64
+		replayed   map[uint64]struct{}
+	}
+)
+
+// ReplayLast replays the last n retained messages.
+func ReplayLast(n int) Replay {
+	return Replay{last: n}
+}
+
+// ReplaySince replays the retained messages with a sequence greater than seq.
+func ReplaySince(seq uint64) Replay {
+	return Replay{since: seq, all: true}
+}
+
+// Retain keeps the last size messages published on topics matching the given topics.
+// Wildcards are allowed, every call creates a new buffer.
+func (h *Hub) Retain(size int, topics ...string) {
+	if size <= 0 {
+		panic("hub: retention size must be greater than zero")
+	}
+
+	h.retention.matcher.Subscribe(topics, newRingBuffer(size))
+}
+
+// Retained returns the retained messages matching the topics selected by replay, ordered by sequence.
+func (h *Hub) Retained(replay Replay, topics ...string) []Message {
+	return h.retention.messages(replay, topics)
+}
+
+// SubscribeWithReplay create a blocking subscription which first receives the retained messages selected by replay
+// and then the live ones. Messages published while replaying are delivered after the replay without duplicates.
+func (h *Hub) SubscribeWithReplay(cap int, replay Replay, topics ...string) Subscription {
+	sub := &replayingSubscriber{
+		blockingSubscriber: newBlockingSubscriber(cap),
+		done:               make(chan struct{}),
+		replaying:          true,
+		limit:              1,
+		replayed:           make(map[uint64]struct{}),
+	}
+	sub.drained = sync.NewCond(&sub.mu)
+	if size := h.retention.size(); size > sub.limit {
+		sub.limit = size
+	}
+
+	subscription := h.subscribe(topics, func(_ alertFunc) subscriber {
+		return sub
+	})
+	retained := h.retention.messages(replay, topics)
+
+	go sub.replay(retained)
+
+	return subscription
+}
+
+func newRetention() *retention {
+	return &retention{
+		matcher: newCSTrieMatcher(),
+	}
+}
+
+func (r *retention) retain(m Message) {
+	for _, sub := range r.matcher.Lookup(m.Topic()) {
+		sub.Set(m)
+	}
+}
+
+// size returns the number of messages the buffers retain at most.
+func (r *retention) size() int {
+	size := 0
+	for _, s := range r.matcher.Subscriptions() {
+		if buffer, ok := s.subscriber.(*ringBuffer); ok {
+			size += len(buffer.messages)
+		}
+	}
+	return size
+}
+
+func (r *retention) messages(replay Replay, topics []string) []Message {
+	seen := make(map[uint64]struct{})
+	result := make([]Message, 0)
+
+	for _, s := range r.matcher.Subscriptions() {
+		buffer, ok := s.subscriber.(*ringBuffer)
+		if !ok {
+			continue
+		}
+
+		for _, m := range buffer.snapshot() {
+			if _, ok := seen[m.Sequence]; ok {
+				continue
+			}
+
+			if replay.all && m.Sequence <= replay.since {
+				continue
+			}
+
+			if !matchAny(topics, m.Topic()) {
+				continue
+			}
+
+			seen[m.Sequence] = struct{}{}
+			result = append(result, m)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Sequence < result[j].Sequence
+	})
+
+	if !replay.all {
+		if replay.last <= 0 {
+			return result[:0]
+		}
+
+		if len(result) > replay.last {
+			result = result[len(result)-replay.last:]
+		}
+	}
+
+	return result
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{
+		messages: make([]Message, size),
+	}
+}
+
+// Set stores the message, overwriting the oldest one when the buffer is full.
+func (b *ringBuffer) Set(m Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.messages[b.next] = m
+	b.next = (b.next + 1) % len(b.messages)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Ch returns nil, ring buffers are never consumed through a channel.
+func (b *ringBuffer) Ch() <-chan Message {
+	return nil
+}
+
+// Close does nothing, the retained messages are kept.
+func (b *ringBuffer) Close() {}
+
+// snapshot returns the retained messages from the oldest to the newest.
+func (b *ringBuffer) snapshot() []Message {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.full {
+		return append([]Message{}, b.messages[:b.next]...)
+	}
+
+	result := make([]Message, 0, len(b.messages))
+	result = append(result, b.messages[b.next:]...)
+	return append(result, b.messages[:b.next]...)
+}
+
+// Set holds the message back while replaying, otherwise it is passed to the wrapped subscriber.
+func (s *replayingSubscriber) Set(m Message) {
+	s.mu.Lock()
+	for s.replaying && !s.closed && len(s.pending) >= s.limit {
+		s.drained.Wait()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if s.replaying {
+		s.pending = append(s.pending, m)
+		atomic.StoreInt64(&s.pendingLen, int64(len(s.pending)))
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	s.blockingSubscriber.Set(m)
+}
+
+// depth returns the number of buffered messages, held back ones included, and the buffer capacity.
+func (s *replayingSubscriber) depth() (int, int) {
+	length, capacity := s.blockingSubscriber.depth()
+	return length + int(atomic.LoadInt64(&s.pendingLen)), capacity
+}
+
+// Close stops the replay and closes the wrapped subscriber.
+func (s *replayingSubscriber) Close() {
+	// done unblocks a replayed message waiting for the consumer, so sendMu can be taken.
+	s.onceDone.Do(func() {
+		close(s.done)
+	})
+
+	s.mu.Lock()
+	s.closed = true
+	s.drained.Broadcast()
+	s.mu.Unlock()
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.blockingSubscriber.Close()
+}
+
+// replay sends the retained messages and then the messages held back meanwhile.
+func (s *replayingSubscriber) replay(retained []Message) {
+	for _, m := range retained {
+		if !s.send(m) {
+			return
+		}
+		s.replayed[m.Sequence] = struct{}{}
+	}
+
+	for {
+		s.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		atomic.StoreInt64(&s.pendingLen, 0)
+		s.drained.Broadcast()
+		if len(pending) == 0 {
+			s.replaying = false
+			s.replayed = nil
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		for _, m := range pending {
+			if _, ok := s.replayed[m.Sequence]; ok {
+				continue
+			}
+
+			if !s.send(m) {
+				return
+			}
+		}
+	}
+}
+
+// send passes a replayed message to the wrapped subscriber, sendMu is held so it is never closed meanwhile.
+func (s *replayingSubscriber) send(m Message) bool {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+
+	if closed {
+		return false
+	}
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	// done is closed before the channel, a closed channel is never selected below.
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	select {
+	case s.ch <- m:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func matchAny(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
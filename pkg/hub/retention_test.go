@@ -0,0 +1,145 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func sequences(messages []Message) []uint64 {
+	result := make([]uint64, 0, len(messages))
+	for _, m := range messages {
+		result = append(result, m.Sequence)
+	}
+	return result
+}
+
+func receiveAll(t *testing.T, sub Subscription, n int) []Message {
+	t.Helper()
+
+	result := make([]Message, 0, n)
+	for len(result) < n {
+		select {
+		case m := <-sub.Receiver:
+			result = append(result, m)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d messages, want %d", len(result), n)
+		}
+	}
+	return result
+}
+
+func TestHub_Retained(t *testing.T) {
+	h := New()
+	h.Retain(3, "orders.*")
+	h.Retain(2, "orders.created")
+
+	for _, name := range []string{"orders.created", "orders.paid", "payments.created", "orders.created", "orders.shipped"} {
+		h.Publish(Message{Name: name})
+	}
+
+	// A message retained by both buffers is returned once.
+	all := h.Retained(ReplaySince(0), "orders.*")
+	if got := sequences(all); len(got) != 4 || got[0] != 1 || got[1] != 2 || got[2] != 4 || got[3] != 5 {
+		t.Fatalf("unexpected retained messages %v", got)
+	}
+
+	if got := sequences(h.Retained(ReplayLast(2), "orders.*")); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Fatalf("unexpected last messages %v", got)
+	}
+
+	if got := sequences(h.Retained(ReplaySince(2), "orders.created")); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("unexpected messages since 2 %v", got)
+	}
+
+	if got := h.Retained(ReplayLast(0), "orders.*"); len(got) != 0 {
+		t.Fatalf("unexpected messages %v", sequences(got))
+	}
+}
+
+func TestHub_SubscribeWithReplayDeliversRetainedThenLiveMessagesOnce(t *testing.T) {
+	h := New()
+	h.Retain(10, "orders.*")
+
+	for i := 0; i < 3; i++ {
+		h.Publish(Message{Name: "orders.created"})
+	}
+
+	// The subscription buffers nothing, live messages are published while the replay waits for the consumer.
+	sub := h.SubscribeWithReplay(0, ReplaySince(1), "orders.*")
+	defer h.Unsubscribe(sub)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			h.Publish(Message{Name: "orders.paid"})
+		}
+	}()
+
+	got := sequences(receiveAll(t, sub, 5))
+	<-done
+
+	for i, seq := range got {
+		if seq != uint64(i+2) {
+			t.Fatalf("unexpected sequences %v", got)
+		}
+	}
+
+	select {
+	case m := <-sub.Receiver:
+		t.Fatalf("unexpected message %d", m.Sequence)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestHub_StatsDoNotWaitForAReplayingConsumer(t *testing.T) {
+	h := New()
+	h.Retain(10, "a")
+	h.Publish(Message{Name: "a"})
+	h.Publish(Message{Name: "a"})
+
+	sub := h.SubscribeWithReplay(0, ReplayLast(10), "a")
+	defer h.Unsubscribe(sub)
+
+	// The replay is blocked on the first message nobody receives.
+	time.Sleep(20 * time.Millisecond)
+
+	statsCh := make(chan Stats, 1)
+	go func() {
+		statsCh <- h.Stats()
+	}()
+
+	select {
+	case <-statsCh:
+	case <-time.After(time.Second):
+		t.Fatal("Stats blocked by the replay")
+	}
+}
+
+func TestHub_UnsubscribeDuringReplay(t *testing.T) {
+	h := New()
+	h.Retain(10, "a")
+	for i := 0; i < 5; i++ {
+		h.Publish(Message{Name: "a"})
+	}
+
+	sub := h.SubscribeWithReplay(0, ReplayLast(10), "a")
+	receiveAll(t, sub, 1)
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		h.Unsubscribe(sub)
+		close(unsubscribed)
+	}()
+
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe blocked by the replay")
+	}
+
+	// The receiver is closed, the replay stopped without sending on it.
+	for range sub.Receiver {
+	}
+	h.Publish(Message{Name: "a"})
+}
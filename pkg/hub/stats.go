@@ -0,0 +1,269 @@
+package hub
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/metrics"
+)
+
+type (
+	// TopicStats holds the counters of the messages published on a topic.
+	// Delivered counts the messages routed to subscribers, Dropped the ones lost by subscribers afterwards.
+	TopicStats struct {
+		Topic     string
+		Published uint64
+		Delivered uint64
+		Dropped   uint64
+	}
+
+	// SubscriptionStats holds the counters and the buffer depth of a subscription.
+	// Delivered counts the messages passed to the subscription, Dropped the ones it lost.
+	SubscriptionStats struct {
+		ID             uint64
+		Topics         []string
+		Delivered      uint64
+		Dropped        uint64
+		BufferLength   int
+		BufferCapacity int
+	}
+
+	// Stats is a snapshot of the hub counters returned by Hub.Stats.
+	Stats struct {
+		Topics        []TopicStats
+		Subscriptions []SubscriptionStats
+
+		PublishCount      uint64
+		PublishLatencyAvg time.Duration
+		PublishLatencyMax time.Duration
+	}
+
+	// stats is shared by a hub and all its children created by With.
+	// At most topicLimit topics are counted by name, the others are counted under OtherTopics.
+	stats struct {
+		nextID uint64
+		topics sync.Map // string -> *topicCounters
+
+		mu         sync.Mutex
+		topicCount int
+		topicLimit int
+
+		latencyCount uint64
+		latencyTotal int64
+		latencyMax   int64
+	}
+
+	topicCounters struct {
+		published uint64
+		delivered uint64
+		dropped   uint64
+	}
+
+	// observedSubscriber wraps the subscribers created by the hub to count their messages.
+	// The dropped messages are received too, so the delivered ones are the received ones minus the dropped ones.
+	observedSubscriber struct {
+		subscriber
+		id       uint64
+		topics   []string
+		received uint64
+		dropped  uint64
+	}
+
+	// depthReporter is implemented by the subscribers buffering messages.
+	depthReporter interface {
+		depth() (length int, capacity int)
+	}
+)
+
+// OtherTopics is the topic name the stats and the metrics use for the topics beyond the limit.
+const OtherTopics = "_other"
+
+// defaultStatsTopics is the default number of topics counted by name.
+const defaultStatsTopics = 1000
+
+func newStats() *stats {
+	return &stats{
+		topicLimit: defaultStatsTopics,
+	}
+}
+
+// LimitStatsTopics sets the number of topics counted by name in the stats and the metric labels,
+// the messages of the other topics are counted under OtherTopics. Default: 1000.
+// It applies to the hub and all its children, topics already counted are kept.
+func (h *Hub) LimitStatsTopics(limit int) {
+	if limit <= 0 {
+		panic("hub: stats topic limit must be greater than zero")
+	}
+
+	h.stats.mu.Lock()
+	h.stats.topicLimit = limit
+	h.stats.mu.Unlock()
+}
+
+// topic returns the counters of the topic and the name they are counted under.
+func (s *stats) topic(topic string) (string, *topicCounters) {
+	if c, ok := s.topics.Load(topic); ok {
+		return topic, c.(*topicCounters)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.topics.Load(topic); ok {
+		return topic, c.(*topicCounters)
+	}
+
+	if s.topicCount >= s.topicLimit {
+		topic = OtherTopics
+		c, _ := s.topics.LoadOrStore(topic, &topicCounters{})
+		return topic, c.(*topicCounters)
+	}
+
+	c := &topicCounters{}
+	s.topics.Store(topic, c)
+	s.topicCount++
+	return topic, c
+}
+
+func (s *stats) published(topic string, subscribers int, start time.Time) {
+	topic, c := s.topic(topic)
+	atomic.AddUint64(&c.published, 1)
+	atomic.AddUint64(&c.delivered, uint64(subscribers))
+
+	elapsed := int64(time.Since(start))
+	atomic.AddUint64(&s.latencyCount, 1)
+	atomic.AddInt64(&s.latencyTotal, elapsed)
+	for {
+		max := atomic.LoadInt64(&s.latencyMax)
+		if elapsed <= max || atomic.CompareAndSwapInt64(&s.latencyMax, max, elapsed) {
+			break
+		}
+	}
+
+	labels := []metrics.Label{{Name: "topic", Value: topic}}
+	metrics.IncrCounterWithLabels([]string{"hub", "published"}, 1, labels)
+	metrics.IncrCounterWithLabels([]string{"hub", "delivered"}, float32(subscribers), labels)
+	metrics.MeasureSinceWithLabels([]string{"hub", "publish"}, start, labels)
+}
+
+func (s *stats) dropped(topic string) {
+	topic, c := s.topic(topic)
+	atomic.AddUint64(&c.dropped, 1)
+	metrics.IncrCounterWithLabels([]string{"hub", "dropped"}, 1, []metrics.Label{{Name: "topic", Value: topic}})
+}
+
+// subscribe creates the subscriber with an alertFunc counting its dropped messages and subscribes it.
+func (h *Hub) subscribe(topics []string, newSubscriber func(alert alertFunc) subscriber) Subscription {
+	sub := &observedSubscriber{
+		id:     atomic.AddUint64(&h.stats.nextID, 1),
+		topics: topics,
+	}
+
+	sub.subscriber = newSubscriber(func(msg Message) {
+		atomic.AddUint64(&sub.dropped, 1)
+		h.stats.dropped(msg.Topic())
+
+		if msg.Topic() == AlertTopic {
+			// Avoid an endless loop when the subscription is subscribed on AlertTopic.
+			return
+		}
+		h.alert(1, topics)
+	})
+
+	subscription := h.matcher.Subscribe(topics, sub)
+	subscription.ID = sub.id
+
+	return subscription
+}
+
+// Set counts the message and passes it to the wrapped subscriber.
+func (s *observedSubscriber) Set(msg Message) {
+	atomic.AddUint64(&s.received, 1)
+	s.subscriber.Set(msg)
+}
+
+func (s *observedSubscriber) stats() SubscriptionStats {
+	length, capacity := bufferDepth(s.subscriber)
+
+	// dropped is loaded first, every dropped message is already received.
+	dropped := atomic.LoadUint64(&s.dropped)
+	received := atomic.LoadUint64(&s.received)
+
+	return SubscriptionStats{
+		ID:             s.id,
+		Topics:         s.topics,
+		Delivered:      received - dropped,
+		Dropped:        dropped,
+		BufferLength:   length,
+		BufferCapacity: capacity,
+	}
+}
+
+func bufferDepth(s subscriber) (int, int) {
+	if d, ok := s.(depthReporter); ok {
+		return d.depth()
+	}
+	return 0, 0
+}
+
+// Stats returns a snapshot of the topic and subscription counters and of the publish latency.
+// Topics and subscriptions are sorted by name and by id.
+func (h *Hub) Stats() Stats {
+	var result Stats
+
+	h.stats.topics.Range(func(key, value interface{}) bool {
+		c := value.(*topicCounters)
+		result.Topics = append(result.Topics, TopicStats{
+			Topic:     key.(string),
+			Published: atomic.LoadUint64(&c.published),
+			Delivered: atomic.LoadUint64(&c.delivered),
+			Dropped:   atomic.LoadUint64(&c.dropped),
+		})
+		return true
+	})
+	sort.Slice(result.Topics, func(i, j int) bool {
+		return result.Topics[i].Topic < result.Topics[j].Topic
+	})
+
+	// A subscription on several topics is returned once per topic by the matcher.
+	seen := make(map[*observedSubscriber]struct{})
+	for _, s := range h.matcher.Subscriptions() {
+		sub, ok := s.subscriber.(*observedSubscriber)
+		if !ok {
+			continue
+		}
+		if _, ok = seen[sub]; ok {
+			continue
+		}
+		seen[sub] = struct{}{}
+		result.Subscriptions = append(result.Subscriptions, sub.stats())
+	}
+	sort.Slice(result.Subscriptions, func(i, j int) bool {
+		return result.Subscriptions[i].ID < result.Subscriptions[j].ID
+	})
+
+	result.PublishCount = atomic.LoadUint64(&h.stats.latencyCount)
+	if result.PublishCount > 0 {
+		result.PublishLatencyAvg = time.Duration(atomic.LoadInt64(&h.stats.latencyTotal) / int64(result.PublishCount))
+	}
+	result.PublishLatencyMax = time.Duration(atomic.LoadInt64(&h.stats.latencyMax))
+
+	return result
+}
+
+// ReportMetrics sets the buffer depth gauges of every subscription.
+// Counters and publish latency are emitted on every Publish, this one could be called periodically.
+func (h *Hub) ReportMetrics() {
+	subscriptions := h.Stats().Subscriptions
+	metrics.SetGauge([]string{"hub", "subscriptions"}, float32(len(subscriptions)))
+
+	for _, s := range subscriptions {
+		labels := []metrics.Label{{Name: "subscription", Value: strconv.FormatUint(s.ID, 10)}}
+		metrics.SetGaugeWithLabels([]string{"hub", "subscription", "buffered"}, float32(s.BufferLength), labels)
+		metrics.SetGaugeWithLabels([]string{"hub", "subscription", "delivered"}, float32(s.Delivered), labels)
+		metrics.SetGaugeWithLabels([]string{"hub", "subscription", "dropped"}, float32(s.Dropped), labels)
+	}
+}
@@ -0,0 +1,64 @@
+package hub
+
+import "testing"
+
+func TestHub_Stats(t *testing.T) {
+	h := New()
+	blocking := h.Subscribe(10, "orders.*", "orders.#")
+	nonBlocking := h.NonBlockingSubscribe(1, "orders.created")
+
+	h.Publish(Message{Name: "orders.created"})
+	h.Publish(Message{Name: "orders.created"})
+	h.Publish(Message{Name: "payments.created"})
+
+	stats := h.Stats()
+
+	// The message dropped by the nonblocking subscription is notified on AlertTopic.
+	if stats.PublishCount != 4 {
+		t.Fatalf("expected 4 publishes, got %d", stats.PublishCount)
+	}
+
+	if len(stats.Topics) != 3 || stats.Topics[0].Topic != AlertTopic {
+		t.Fatalf("expected 3 topics, got %+v", stats.Topics)
+	}
+
+	orders := stats.Topics[1]
+	if orders.Topic != "orders.created" || orders.Published != 2 || orders.Delivered != 4 || orders.Dropped != 1 {
+		t.Fatalf("unexpected topic stats %+v", orders)
+	}
+
+	if len(stats.Subscriptions) != 2 {
+		t.Fatalf("expected 2 subscriptions, got %+v", stats.Subscriptions)
+	}
+
+	first, second := stats.Subscriptions[0], stats.Subscriptions[1]
+	if first.ID != blocking.ID || first.Delivered != 2 || first.Dropped != 0 || first.BufferLength != 2 || first.BufferCapacity != 10 {
+		t.Fatalf("unexpected blocking subscription stats %+v", first)
+	}
+
+	if second.ID != nonBlocking.ID || second.Delivered != 1 || second.Dropped != 1 || second.BufferLength != 1 {
+		t.Fatalf("unexpected nonblocking subscription stats %+v", second)
+	}
+}
+
+func TestHub_StatsCountsTopicsBeyondTheLimitTogether(t *testing.T) {
+	h := New()
+	h.LimitStatsTopics(2)
+
+	for _, name := range []string{"orders.created", "orders.paid", "orders.shipped", "orders.cancelled", "orders.created"} {
+		h.Publish(Message{Name: name})
+	}
+
+	stats := h.Stats()
+	if len(stats.Topics) != 3 {
+		t.Fatalf("expected 3 topics, got %+v", stats.Topics)
+	}
+
+	if other := stats.Topics[0]; other.Topic != OtherTopics || other.Published != 2 {
+		t.Fatalf("unexpected other topics stats %+v", other)
+	}
+
+	if created := stats.Topics[1]; created.Topic != "orders.created" || created.Published != 2 {
+		t.Fatalf("unexpected topic stats %+v", created)
+	}
+}
@@ -0,0 +1,205 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorTopic is used to notify when a handler of a SubscribeFunc subscription returns an error or panics
+// and no OnError function was set. You can subscribe on this topic and log or send metrics.
+const ErrorTopic = "hub.subscription.handlererror"
+
+// OverflowPolicy tells what a SubscribeFunc subscription does with a message when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// Block waits for room in the buffer, up to BlockTimeout when it is set.
+	Block OverflowPolicy = iota
+	// DropOldest removes the oldest buffered message to make room for the new one.
+	DropOldest
+	// DropNewest ignores the new message.
+	DropNewest
+)
+
+type (
+	// HandlerFunc handles the messages of a SubscribeFunc subscription.
+	HandlerFunc func(ctx context.Context, m Message) error
+
+	// SubscribeOptions configure a SubscribeFunc subscription.
+	SubscribeOptions struct {
+		Topics []string
+		// Workers is the number of goroutines running the handler. Default: 1.
+		// Messages are handled in order only with a single worker.
+		Workers int
+		// BufferSize is the number of messages waiting for a worker. Default: 10.
+		BufferSize int
+		// Overflow is the policy applied when the buffer is full. Default: Block.
+		Overflow OverflowPolicy
+		// BlockTimeout limits how long Publish waits with the Block policy, the message is dropped after it.
+		// Zero means forever.
+		BlockTimeout time.Duration
+		// OnError is called with the errors returned by the handler and the recovered panics.
+		// When nil, the errors are published on ErrorTopic.
+		OnError func(m Message, err error)
+	}
+
+	// funcSubscriber buffers messages and runs a handler on a pool of workers.
+	funcSubscriber struct {
+		queue   chan Message
+		policy  OverflowPolicy
+		timeout time.Duration
+		alert   alertFunc
+
+		doneCh    chan struct{}
+		onceClose sync.Once
+	}
+)
+
+// SubscribeFunc create a subscription running the handler for every message on a managed worker pool.
+// The subscription is removed when the context ends. Dropped messages are notified on AlertTopic.
+// The Receiver of the returned Subscription is nil, messages are only passed to the handler.
+func (h *Hub) SubscribeFunc(ctx context.Context, handler HandlerFunc, opts SubscribeOptions) Subscription {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10
+	}
+
+	if opts.OnError == nil {
+		opts.OnError = func(m Message, err error) {
+			h.handlerError(m, err, opts.Topics)
+		}
+	}
+
+	var sub *funcSubscriber
+	subscription := h.subscribe(opts.Topics, func(alert alertFunc) subscriber {
+		sub = &funcSubscriber{
+			queue:   make(chan Message, opts.BufferSize),
+			policy:  opts.Overflow,
+			timeout: opts.BlockTimeout,
+			alert:   alert,
+			doneCh:  make(chan struct{}),
+		}
+		return sub
+	})
+
+	for i := 0; i < opts.Workers; i++ {
+		go sub.work(ctx, handler, opts.OnError)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.Unsubscribe(subscription)
+		case <-sub.doneCh:
+		}
+	}()
+
+	return subscription
+}
+
+func (h *Hub) handlerError(m Message, err error, topics []string) {
+	if m.Topic() == ErrorTopic {
+		// Avoid an endless loop when the failing handler is subscribed on ErrorTopic.
+		return
+	}
+
+	h.Publish(Message{
+		Name: ErrorTopic,
+		Fields: Fields{
+			"error":   err.Error(),
+			"message": m.Topic(),
+			"topic":   topics,
+		},
+	})
+}
+
+// Set puts the message into the buffer applying the overflow policy.
+func (s *funcSubscriber) Set(msg Message) {
+	select {
+	case <-s.doneCh:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.queue <- msg:
+		default:
+			s.alert(msg)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- msg:
+				return
+			default:
+			}
+
+			select {
+			case old := <-s.queue:
+				s.alert(old)
+			default:
+			}
+		}
+	default:
+		var timeoutCh <-chan time.Time
+		if s.timeout > 0 {
+			timer := time.NewTimer(s.timeout)
+			defer timer.Stop()
+			timeoutCh = timer.C
+		}
+
+		select {
+		case s.queue <- msg:
+		case <-timeoutCh:
+			s.alert(msg)
+		case <-s.doneCh:
+		}
+	}
+}
+
+// Ch returns nil, the messages are passed to the handler.
+func (s *funcSubscriber) Ch() <-chan Message {
+	return nil
+}
+
+// Close stops the workers. Buffered messages are not handled.
+func (s *funcSubscriber) Close() {
+	s.onceClose.Do(func() {
+		close(s.doneCh)
+	})
+}
+
+// depth returns the number of buffered messages and the buffer capacity.
+func (s *funcSubscriber) depth() (int, int) {
+	return len(s.queue), cap(s.queue)
+}
+
+func (s *funcSubscriber) work(ctx context.Context, handler HandlerFunc, onError func(Message, error)) {
+	for {
+		select {
+		case msg := <-s.queue:
+			if err := s.handle(ctx, handler, msg); err != nil {
+				onError(msg, err)
+			}
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+func (s *funcSubscriber) handle(ctx context.Context, handler HandlerFunc, msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hub: handler panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, msg)
+}
@@ -0,0 +1,207 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingHandler reports the messages on started and returns once release is closed.
+func blockingHandler(started chan<- string, release <-chan struct{}) HandlerFunc {
+	return func(_ context.Context, m Message) error {
+		started <- m.Topic()
+		<-release
+		return nil
+	}
+}
+
+func handled(t *testing.T, started <-chan string, n int) []string {
+	t.Helper()
+
+	result := make([]string, 0, n)
+	for len(result) < n {
+		select {
+		case name := <-started:
+			result = append(result, name)
+		case <-time.After(time.Second):
+			t.Fatalf("handled %v, want %d messages", result, n)
+		}
+	}
+	return result
+}
+
+// fillBuffer publishes orders.1 to orders.4 on a subscription with a single busy worker and a buffer of 2,
+// so orders.4 overflows.
+func fillBuffer(t *testing.T, h *Hub, overflow OverflowPolicy, timeout time.Duration) (<-chan string, chan struct{}) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	started, release := make(chan string), make(chan struct{})
+	h.SubscribeFunc(ctx, blockingHandler(started, release), SubscribeOptions{
+		Topics:       []string{"orders.*"},
+		BufferSize:   2,
+		Overflow:     overflow,
+		BlockTimeout: timeout,
+	})
+
+	h.Publish(Message{Name: "orders.1"})
+	if got := handled(t, started, 1); got[0] != "orders.1" {
+		t.Fatalf("unexpected first message %v", got)
+	}
+
+	for i := 2; i <= 4; i++ {
+		h.Publish(Message{Name: fmt.Sprintf("orders.%d", i)})
+	}
+
+	return started, release
+}
+
+func TestSubscribeFunc_DropOldest(t *testing.T) {
+	h := New()
+	alerts := h.Subscribe(10, AlertTopic)
+
+	started, release := fillBuffer(t, h, DropOldest, 0)
+	close(release)
+
+	if got := strings.Join(handled(t, started, 2), " "); got != "orders.3 orders.4" {
+		t.Fatalf("unexpected handled messages %s", got)
+	}
+	if got := receiveAll(t, alerts, 1); got[0].Fields["missed"] != 1 {
+		t.Fatalf("unexpected alert %v", got[0].Fields)
+	}
+}
+
+func TestSubscribeFunc_DropNewest(t *testing.T) {
+	h := New()
+	alerts := h.Subscribe(10, AlertTopic)
+
+	started, release := fillBuffer(t, h, DropNewest, 0)
+	close(release)
+
+	if got := strings.Join(handled(t, started, 2), " "); got != "orders.2 orders.3" {
+		t.Fatalf("unexpected handled messages %s", got)
+	}
+	if got := receiveAll(t, alerts, 1); got[0].Fields["missed"] != 1 {
+		t.Fatalf("unexpected alert %v", got[0].Fields)
+	}
+}
+
+func TestSubscribeFunc_BlockDropsAfterTheTimeout(t *testing.T) {
+	h := New()
+	alerts := h.Subscribe(10, AlertTopic)
+
+	start := time.Now()
+	started, release := fillBuffer(t, h, Block, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("publish returned after %s, before the timeout", elapsed)
+	}
+	close(release)
+
+	if got := strings.Join(handled(t, started, 2), " "); got != "orders.2 orders.3" {
+		t.Fatalf("unexpected handled messages %s", got)
+	}
+	if got := receiveAll(t, alerts, 1); got[0].Fields["missed"] != 1 {
+		t.Fatalf("unexpected alert %v", got[0].Fields)
+	}
+}
+
+func TestSubscribeFunc_BlockWaitsForRoom(t *testing.T) {
+	h := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started, release := make(chan string), make(chan struct{})
+	h.SubscribeFunc(ctx, blockingHandler(started, release), SubscribeOptions{Topics: []string{"orders.*"}, BufferSize: 1})
+
+	h.Publish(Message{Name: "orders.1"})
+	handled(t, started, 1)
+	h.Publish(Message{Name: "orders.2"})
+
+	publishedCh := make(chan struct{})
+	go func() {
+		defer close(publishedCh)
+		h.Publish(Message{Name: "orders.3"})
+	}()
+
+	select {
+	case <-publishedCh:
+		t.Fatal("publish did not wait for room in the buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-publishedCh
+
+	if got := strings.Join(handled(t, started, 2), " "); got != "orders.2 orders.3" {
+		t.Fatalf("unexpected handled messages %s", got)
+	}
+}
+
+func TestSubscribeFunc_RecoversPanics(t *testing.T) {
+	h := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	handledCh := make(chan string, 1)
+	h.SubscribeFunc(ctx, func(_ context.Context, m Message) error {
+		if m.Topic() == "orders.1" {
+			panic("boom")
+		}
+		handledCh <- m.Topic()
+		return nil
+	}, SubscribeOptions{
+		Topics: []string{"orders.*"},
+		OnError: func(m Message, err error) {
+			errCh <- fmt.Errorf("%s: %w", m.Topic(), err)
+		},
+	})
+
+	h.Publish(Message{Name: "orders.1"})
+	h.Publish(Message{Name: "orders.2"})
+
+	select {
+	case err := <-errCh:
+		if err.Error() != "orders.1: hub: handler panic: boom" {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the panic was not reported")
+	}
+
+	// The worker survives the panic.
+	select {
+	case name := <-handledCh:
+		if name != "orders.2" {
+			t.Fatalf("unexpected handled message %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the next message was not handled")
+	}
+}
+
+func TestSubscribeFunc_PublishesErrorsOnErrorTopic(t *testing.T) {
+	h := New()
+	errs := h.Subscribe(10, ErrorTopic)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h.SubscribeFunc(ctx, func(context.Context, Message) error {
+		return errors.New("invalid order")
+	}, SubscribeOptions{Topics: []string{"orders.*"}})
+
+	h.Publish(Message{Name: "orders.1"})
+
+	m := receiveAll(t, errs, 1)[0]
+	if m.Fields["error"] != "invalid order" || m.Fields["message"] != "orders.1" {
+		t.Fatalf("unexpected error message %v", m.Fields)
+	}
+}
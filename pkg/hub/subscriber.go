@@ -3,7 +3,8 @@ package hub
 import "sync"
 
 type (
-	alertFunc func(missed int)
+	// alertFunc is called with every message a subscriber drops.
+	alertFunc func(msg Message)
 
 	nonBlockingSubscriber struct {
 		ch        chan Message
@@ -36,7 +37,7 @@ func (s *nonBlockingSubscriber) Set(msg Message) {
 	select {
 	case s.ch <- msg:
 	default:
-		s.alert(1)
+		s.alert(msg)
 	}
 }
 
@@ -45,6 +46,11 @@ func (s *nonBlockingSubscriber) Ch() <-chan Message {
 	return s.ch
 }
 
+// depth returns the number of buffered messages and the buffer capacity.
+func (s *nonBlockingSubscriber) depth() (int, int) {
+	return len(s.ch), cap(s.ch)
+}
+
 // Close will close the internal channel and stop receiving messages.
 func (s *nonBlockingSubscriber) Close() {
 	s.onceClose.Do(func() {
@@ -73,6 +79,11 @@ func (s *blockingSubscriber) Ch() <-chan Message {
 	return s.ch
 }
 
+// depth returns the number of buffered messages and the buffer capacity.
+func (s *blockingSubscriber) depth() (int, int) {
+	return len(s.ch), cap(s.ch)
+}
+
 // Close will close the internal channel and stop receiving messages.
 func (s *blockingSubscriber) Close() {
 	s.onceClose.Do(func() {
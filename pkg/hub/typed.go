@@ -0,0 +1,210 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+)
+
+// PayloadTypeField is the message field holding the type name of a typed payload.
+const PayloadTypeField = "hub.payload.type"
+
+var (
+	// ErrTopicNotRegistered is returned when no payload type is registered for a topic.
+	ErrTopicNotRegistered = errors.New("hub: no payload type registered for topic")
+	// ErrPayloadType is returned when a payload type doesn't match the type registered for its topic.
+	ErrPayloadType = errors.New("hub: payload type doesn't match the registered type")
+)
+
+type (
+	// Registry binds topics to payload types. Topics could use wildcards,
+	// an exact topic wins over a pattern and patterns are checked in registration order.
+	Registry struct {
+		mu       sync.RWMutex
+		types    map[string]reflect.Type
+		patterns []string
+	}
+
+	// TypedHub publishes and subscribes with payloads encoded in the message Body.
+	TypedHub struct {
+		hub        *Hub
+		registry   *Registry
+		marshaller interfaces.Marshaller
+	}
+
+	// TypedHandlerFunc handles the messages of a typed subscription with their decoded payload.
+	// The payload has the type registered for the message topic.
+	TypedHandlerFunc func(ctx context.Context, m Message, payload interface{}) error
+)
+
+// NewRegistry create and return a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register binds the topic to the type of the prototype, pointers are dereferenced.
+// Registering the same topic again is only allowed with the same type.
+func (r *Registry) Register(topic string, prototype interface{}) error {
+	if prototype == nil {
+		return fmt.Errorf("prototype must be not nil")
+	}
+
+	t := payloadType(prototype)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if registered, ok := r.types[topic]; ok {
+		if registered != t {
+			return fmt.Errorf("topic %s is already registered with %s", topic, registered)
+		}
+		return nil
+	}
+
+	r.types[topic] = t
+	r.patterns = append(r.patterns, topic)
+
+	return nil
+}
+
+// TypeOf returns the payload type registered for the topic.
+func (r *Registry) TypeOf(topic string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if t, ok := r.types[topic]; ok {
+		return t, true
+	}
+
+	for _, pattern := range r.patterns {
+		if Match(pattern, topic) {
+			return r.types[pattern], true
+		}
+	}
+
+	return nil, false
+}
+
+// Validate returns an error when the payload type doesn't match the type registered for the topic.
+func (r *Registry) Validate(topic string, payload interface{}) error {
+	t, ok := r.TypeOf(topic)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTopicNotRegistered, topic)
+	}
+
+	if payload == nil {
+		return fmt.Errorf("%w: topic %s expects %s, got nil", ErrPayloadType, topic, t)
+	}
+
+	if got := payloadType(payload); got != t {
+		return fmt.Errorf("%w: topic %s expects %s, got %s", ErrPayloadType, topic, t, got)
+	}
+
+	return nil
+}
+
+// NewTypedHub returns TypedHub or a configuration error.
+func NewTypedHub(h *Hub, registry *Registry, marshaller interfaces.Marshaller) (*TypedHub, error) {
+	if h == nil {
+		return nil, fmt.Errorf("hub must be not nil")
+	}
+
+	if registry == nil {
+		return nil, fmt.Errorf("registry must be not nil")
+	}
+
+	if marshaller == nil {
+		return nil, fmt.Errorf("marshaller must be not nil")
+	}
+
+	return &TypedHub{
+		hub:        h,
+		registry:   registry,
+		marshaller: marshaller,
+	}, nil
+}
+
+// Hub returns the underlying hub.
+func (t *TypedHub) Hub() *Hub {
+	return t.hub
+}
+
+// Publish validates the payload against the registry, encodes it into the Body and publishes the message.
+func (t *TypedHub) Publish(topic string, payload interface{}, fields Fields) error {
+	if err := t.registry.Validate(topic, payload); err != nil {
+		return err
+	}
+
+	body, err := t.marshaller.Marshall(payload)
+	if err != nil {
+		return err
+	}
+
+	msg := Message{
+		Name:   topic,
+		Body:   body,
+		Fields: make(Fields, len(fields)+1),
+	}
+	for k, v := range fields {
+		msg.Fields[k] = v
+	}
+	msg.Fields[PayloadTypeField] = payloadType(payload).String()
+
+	t.hub.Publish(msg)
+
+	return nil
+}
+
+// Decode returns the payload of the message as a value of the type registered for its topic.
+func (t *TypedHub) Decode(m Message) (interface{}, error) {
+	typ, ok := t.registry.TypeOf(m.Topic())
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTopicNotRegistered, m.Topic())
+	}
+
+	payload := reflect.New(typ)
+	if err := t.marshaller.Unmarshall(m.Body, payload.Interface()); err != nil {
+		return nil, err
+	}
+
+	return payload.Elem().Interface(), nil
+}
+
+// DecodeInto decodes the payload of the message into v, which must be a pointer to the registered type.
+func (t *TypedHub) DecodeInto(m Message, v interface{}) error {
+	if err := t.registry.Validate(m.Topic(), v); err != nil {
+		return err
+	}
+
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		return fmt.Errorf("v must be a pointer")
+	}
+
+	return t.marshaller.Unmarshall(m.Body, v)
+}
+
+// SubscribeFunc create a subscription running the handler with the decoded payload of every message.
+// Decoding errors are handled like the handler errors, see SubscribeOptions.OnError.
+func (t *TypedHub) SubscribeFunc(ctx context.Context, handler TypedHandlerFunc, opts SubscribeOptions) Subscription {
+	return t.hub.SubscribeFunc(ctx, func(ctx context.Context, m Message) error {
+		payload, err := t.Decode(m)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, m, payload)
+	}, opts)
+}
+
+func payloadType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub/internal/hubtest"
+)
+
+type orderCreated struct {
+	ID string
+}
+
+type orderDeleted struct {
+	ID string
+}
+
+func TestTypedHub(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register("orders.*.created", orderCreated{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("orders.*.created", orderDeleted{}); err == nil {
+		t.Fatal("expected an error registering another type")
+	}
+
+	typed, err := NewTypedHub(New(), registry, hubtest.Marshaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = typed.Publish("orders.eu.created", orderDeleted{ID: "1"}, nil); !errors.Is(err, ErrPayloadType) {
+		t.Fatalf("expected ErrPayloadType, got %v", err)
+	}
+
+	if err = typed.Publish("orders.eu.deleted", orderDeleted{ID: "1"}, nil); !errors.Is(err, ErrTopicNotRegistered) {
+		t.Fatalf("expected ErrTopicNotRegistered, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan interface{}, 1)
+	typed.SubscribeFunc(ctx, func(_ context.Context, _ Message, payload interface{}) error {
+		received <- payload
+		return nil
+	}, SubscribeOptions{Topics: []string{"orders.#"}})
+
+	if err = typed.Publish("orders.eu.created", &orderCreated{ID: "1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload != (orderCreated{ID: "1"}) {
+			t.Fatalf("unexpected payload %#v", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("payload was not received")
+	}
+}
@@ -0,0 +1,151 @@
+package mediator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/caching/hybrid/interfaces"
+)
+
+type (
+	// CacheableQuery is implemented by messages whose results can be served from cache.
+	// The result is stored under CacheKey for CacheExpiration. CacheResult returns a pointer to a zero value
+	// of the result type, a cached result is decoded into it, e.g. new(*TodoContract) for a *TodoContract result.
+	CacheableQuery interface {
+		Message
+		CacheKey() string
+		CacheExpiration() time.Duration
+		CacheResult() interface{}
+	}
+	// TaggedCacheableQuery lets a CacheableQuery group its cache key under tags,
+	// so a command can evict every key of a tag at once.
+	TaggedCacheableQuery interface {
+		CacheableQuery
+		CacheTags() []string
+	}
+	// CacheInvalidator is implemented by messages that evict cache entries once they are handled successfully.
+	CacheInvalidator interface {
+		Message
+		InvalidatedCacheKeys() []string
+		InvalidatedCacheTags() []string
+	}
+)
+
+// CachingBehaviour serves CacheableQuery results from a hybrid caching source
+// and evicts the entries declared by CacheInvalidator messages from all its layers.
+// The in-memory layer of the other processes keeps an evicted entry until it expires, see hybrid.WithInMemoryExpiration.
+// Tag to key bindings are kept in memory, so tags only evict keys cached by this process.
+type CachingBehaviour struct {
+	cachingSource interfaces.HybridCachingSource
+
+	mu   sync.Mutex
+	tags map[string]map[string]struct{}
+}
+
+// NewCachingBehaviour returns a CachingBehaviour using the given caching source.
+func NewCachingBehaviour(cachingSource interfaces.HybridCachingSource) *CachingBehaviour {
+	return &CachingBehaviour{
+		cachingSource: cachingSource,
+		tags:          make(map[string]map[string]struct{}),
+	}
+}
+
+// Process implements PipelineBehaviour.
+func (c *CachingBehaviour) Process(ctx context.Context, msg Message, next Next) (interface{}, error) {
+	if query, ok := msg.(CacheableQuery); ok {
+		return c.query(ctx, query, next)
+	}
+
+	result, err := next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if invalidator, ok := msg.(CacheInvalidator); ok {
+		if err = c.invalidate(ctx, invalidator); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (c *CachingBehaviour) query(ctx context.Context, query CacheableQuery, next Next) (interface{}, error) {
+	key := query.CacheKey()
+	if key == "" {
+		return next(ctx)
+	}
+
+	if tagged, ok := query.(TaggedCacheableQuery); ok {
+		c.tag(key, tagged.CacheTags())
+	}
+
+	cached := query.CacheResult()
+	if v := reflect.ValueOf(cached); v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, fmt.Errorf("cache result of %s must be a not nil pointer", query.Key())
+	}
+
+	found, err := c.cachingSource.GetValueByKey(ctx, key, cached)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return reflect.ValueOf(cached).Elem().Interface(), nil
+	}
+
+	result, err := next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.cachingSource.SetValue(ctx, key, result, query.CacheExpiration()); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *CachingBehaviour) invalidate(ctx context.Context, invalidator CacheInvalidator) error {
+	keys := append([]string{}, invalidator.InvalidatedCacheKeys()...)
+	keys = append(keys, c.untag(invalidator.InvalidatedCacheTags())...)
+
+	for _, key := range keys {
+		if err := c.cachingSource.DeleteValueByKey(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CachingBehaviour) tag(key string, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (c *CachingBehaviour) untag(tags []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var keys []string
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			keys = append(keys, key)
+		}
+		delete(c.tags, tag)
+	}
+
+	return keys
+}
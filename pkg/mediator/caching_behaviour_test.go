@@ -0,0 +1,179 @@
+package mediator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/caching/hybrid"
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/json"
+)
+
+type (
+	todo struct {
+		ID   string
+		Name string
+	}
+
+	findTodoQuery struct{ ID string }
+
+	renameTodoCommand struct{ ID string }
+
+	todoHandler struct {
+		mu    sync.Mutex
+		calls int
+	}
+
+	memoryCache struct {
+		mu     sync.Mutex
+		values map[string]interface{}
+	}
+
+	// sharedCache stores encoded values like redis does.
+	sharedCache struct {
+		mu     sync.Mutex
+		values map[string][]byte
+	}
+)
+
+func (*findTodoQuery) Key() string                          { return "findTodoQuery" }
+func (q *findTodoQuery) CacheKey() string                   { return "todo:" + q.ID }
+func (*findTodoQuery) CacheExpiration() time.Duration       { return time.Minute }
+func (*findTodoQuery) CacheResult() interface{}             { return new(*todo) }
+func (*renameTodoCommand) Key() string                      { return "renameTodoCommand" }
+func (c *renameTodoCommand) InvalidatedCacheKeys() []string { return []string{"todo:" + c.ID} }
+func (*renameTodoCommand) InvalidatedCacheTags() []string   { return nil }
+
+func (h *todoHandler) Handle(_ context.Context, msg Message) (interface{}, error) {
+	switch m := msg.(type) {
+	case *findTodoQuery:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.calls++
+		return &todo{ID: m.ID, Name: "write tests"}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (h *todoHandler) Calls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls
+}
+
+func (c *memoryCache) GetValueByKey(key string) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *memoryCache) SetValue(key string, value interface{}) error {
+	return c.SetValueWithExpiration(key, value, 0)
+}
+
+func (c *memoryCache) SetValueWithExpiration(key string, value interface{}, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+	return nil
+}
+
+func (c *memoryCache) DeleteValueByKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+}
+
+func (c *sharedCache) GetValueByKey(_ context.Context, key string, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, ok := c.values[key]
+	if !ok {
+		return nil
+	}
+	return json.New().Unmarshall(data, result)
+}
+
+func (c *sharedCache) SetValue(_ context.Context, key string, value interface{}, _ time.Duration) error {
+	data, err := json.New().Marshall(value)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = data
+	return nil
+}
+
+func (c *sharedCache) DeleteValueByKey(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+	return nil
+}
+
+func TestCachingBehaviour_ServesTypedResultsFromEveryLayer(t *testing.T) {
+	ctx := context.Background()
+	shared := &sharedCache{values: make(map[string][]byte)}
+	handler := &todoHandler{}
+
+	newReplica := func() *Mediator {
+		cache := hybrid.New(&memoryCache{values: make(map[string]interface{})}, shared)
+		m, err := NewContext().
+			UseBehaviour(NewCachingBehaviour(cache)).
+			RegisterHandler(&findTodoQuery{}, handler).
+			RegisterHandler(&renameTodoCommand{}, handler).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	send := func(m *Mediator, msg Message) interface{} {
+		result, err := m.Send(ctx, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	first, second := newReplica(), newReplica()
+
+	// A value stored unwrapped by a previous version is ignored.
+	shared.values["todo:1"] = []byte(`{"ID":"1","Name":"stale"}`)
+
+	// A miss calls the handler and fills both layers.
+	if result, ok := send(first, &findTodoQuery{ID: "1"}).(*todo); !ok || result.Name != "write tests" {
+		t.Fatalf("unexpected miss result %#v", result)
+	}
+
+	// The other replica decodes the distributed entry to the handler result type, then serves it from memory.
+	for i := 0; i < 2; i++ {
+		if result, ok := send(second, &findTodoQuery{ID: "1"}).(*todo); !ok || result.ID != "1" || result.Name != "write tests" {
+			t.Fatalf("unexpected hit result %#v", result)
+		}
+	}
+
+	if calls := handler.Calls(); calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+
+	// The invalidation evicts both layers.
+	send(first, &renameTodoCommand{ID: "1"})
+	if result, ok := send(first, &findTodoQuery{ID: "1"}).(*todo); !ok || result.ID != "1" {
+		t.Fatalf("unexpected result %#v", result)
+	}
+
+	if calls := handler.Calls(); calls != 2 {
+		t.Fatalf("handler called %d times, want 2", calls)
+	}
+
+	if _, ok := shared.values["todo:1"]; ok {
+		t.Fatal("the unversioned value was not evicted")
+	}
+}
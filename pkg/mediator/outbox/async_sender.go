@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/google/uuid"
+)
+
+// Option could be used to configure AsyncSender
+type Option func(s *AsyncSender)
+
+// AsyncSender writes mediator messages into the outbox table instead of handling them in process.
+// The rows are published to RabbitMQ later by a Relay, so the message is only sent if the caller's transaction commits.
+type AsyncSender struct {
+	marshaller     interfaces.Marshaller
+	tableName      string
+	exchange       string
+	contentType    string
+	routingKeyFunc func(msg mediator.Message) string
+}
+
+// NewAsyncSender returns AsyncSender or a configuration error.
+func NewAsyncSender(marshaller interfaces.Marshaller, opts ...Option) (*AsyncSender, error) {
+	s := &AsyncSender{
+		marshaller:  marshaller,
+		tableName:   DefaultTableName,
+		contentType: "application/json",
+		routingKeyFunc: func(msg mediator.Message) string {
+			return msg.Key()
+		},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.marshaller == nil {
+		return nil, fmt.Errorf("marshaller must be not nil")
+	}
+
+	if s.tableName == "" {
+		return nil, fmt.Errorf("table name must be not empty")
+	}
+
+	if s.routingKeyFunc == nil {
+		return nil, fmt.Errorf("routing key func must be not nil")
+	}
+
+	return s, nil
+}
+
+// WithTableName configure the outbox table. Default: mediator_outbox.
+func WithTableName(name string) Option {
+	return func(s *AsyncSender) {
+		s.tableName = name
+	}
+}
+
+// WithExchange configure the exchange messages are published to. Default: the default exchange.
+func WithExchange(exchange string) Option {
+	return func(s *AsyncSender) {
+		s.exchange = exchange
+	}
+}
+
+// WithContentType configure the content type matching the marshaller. Default: application/json.
+func WithContentType(contentType string) Option {
+	return func(s *AsyncSender) {
+		s.contentType = contentType
+	}
+}
+
+// WithRoutingKeyFunc configure how the routing key is chosen. Default: the message key.
+func WithRoutingKeyFunc(f func(msg mediator.Message) string) Option {
+	return func(s *AsyncSender) {
+		s.routingKeyFunc = f
+	}
+}
+
+// Send serialises the message and inserts it into the outbox table using db.
+// Pass a *pg.Tx to enlist the message in the caller's transaction.
+// It returns the id of the outbox row, which is also used as the AMQP message id.
+func (s *AsyncSender) Send(ctx context.Context, db orm.DB, msg mediator.Message) (string, error) {
+	payload, err := s.marshaller.Marshall(msg)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	row := &OutboxMessage{
+		Id:            uuid.NewString(),
+		MessageType:   msg.Key(),
+		Exchange:      s.exchange,
+		RoutingKey:    s.routingKeyFunc(msg),
+		ContentType:   s.contentType,
+		Payload:       payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+
+	if _, err = db.ExecContext(ctx, `
+		INSERT INTO ? (id, message_type, exchange, routing_key, content_type, payload, created_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		pg.Ident(s.tableName),
+		row.Id, row.MessageType, row.Exchange, row.RoutingKey, row.ContentType, row.Payload, row.CreatedAt, row.NextAttemptAt,
+	); err != nil {
+		return "", err
+	}
+
+	return row.Id, nil
+}
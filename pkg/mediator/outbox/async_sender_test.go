@@ -0,0 +1,66 @@
+package outbox
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/json"
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+	"github.com/google/uuid"
+)
+
+type orderCreated struct {
+	OrderId string `json:"order_id"`
+}
+
+func (orderCreated) Key() string {
+	return "order.created"
+}
+
+func TestAsyncSender_InsertsTheMessageIntoTheOutbox(t *testing.T) {
+	s, err := NewAsyncSender(json.New(), WithTableName("outbox"), WithExchange("events"), WithRoutingKeyFunc(func(msg mediator.Message) string {
+		return "orders." + msg.Key()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &fakeDB{}
+	id, err := s.Send(context.Background(), db, orderCreated{OrderId: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = uuid.Parse(id); err != nil {
+		t.Fatalf("id %q is not a uuid: %v", id, err)
+	}
+
+	statements := db.takeStatements()
+	if len(statements) != 1 {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+
+	insert := statements[0]
+	for _, want := range []string{
+		`INSERT INTO "outbox"`,
+		`VALUES ('` + id + `', 'order.created', 'events', 'orders.order.created', 'application/json', '\x` + hex.EncodeToString([]byte(`{"order_id":"42"}`)) + `'`,
+	} {
+		if !strings.Contains(insert, want) {
+			t.Fatalf("insert %q does not contain %q", insert, want)
+		}
+	}
+}
+
+func TestNewAsyncSender_RejectsInvalidOptions(t *testing.T) {
+	if _, err := NewAsyncSender(nil); err == nil {
+		t.Fatal("expected an error for a nil marshaller")
+	}
+	if _, err := NewAsyncSender(json.New(), WithTableName("")); err == nil {
+		t.Fatal("expected an error for an empty table name")
+	}
+	if _, err := NewAsyncSender(json.New(), WithRoutingKeyFunc(nil)); err == nil {
+		t.Fatal("expected an error for a nil routing key func")
+	}
+}
@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// DefaultTableName is the outbox table used when no table name is configured.
+const DefaultTableName = "mediator_outbox"
+
+// OutboxMessage is a row of the outbox table.
+// Every row holds a serialised mediator.Message waiting to be published to RabbitMQ.
+type OutboxMessage struct {
+	Id            string     `pg:"id"`
+	MessageType   string     `pg:"message_type"`
+	Exchange      string     `pg:"exchange"`
+	RoutingKey    string     `pg:"routing_key"`
+	ContentType   string     `pg:"content_type"`
+	Payload       []byte     `pg:"payload"`
+	Attempts      int        `pg:"attempts,use_zero"`
+	LastError     string     `pg:"last_error"`
+	CreatedAt     time.Time  `pg:"created_at"`
+	NextAttemptAt time.Time  `pg:"next_attempt_at"`
+	SentAt        *time.Time `pg:"sent_at"`
+}
+
+// CreateTable creates the outbox table and its pending rows index if they do not exist.
+func CreateTable(ctx context.Context, db orm.DB, tableName string) error {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS ? (
+			id              uuid PRIMARY KEY,
+			message_type    text NOT NULL,
+			exchange        text NOT NULL,
+			routing_key     text NOT NULL,
+			content_type    text NOT NULL,
+			payload         bytea NOT NULL,
+			attempts        integer NOT NULL DEFAULT 0,
+			last_error      text,
+			created_at      timestamptz NOT NULL,
+			next_attempt_at timestamptz NOT NULL,
+			sent_at         timestamptz
+		)`, pg.Ident(tableName)); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS ? ON ? (next_attempt_at) WHERE sent_at IS NULL`,
+		pg.Ident(tableName+"_pending_idx"), pg.Ident(tableName)); err != nil {
+		return err
+	}
+
+	return nil
+}
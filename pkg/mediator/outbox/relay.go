@@ -0,0 +1,299 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/logging"
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/streadway/amqp"
+)
+
+// RelayOption could be used to configure Relay
+type RelayOption func(r *Relay)
+
+// Relay publishes pending outbox rows through a Publisher.
+// The publisher must be created WithConfirmation so a row is marked sent only once the broker confirmed it.
+// Failed rows are retried after the retry period until max attempts is reached.
+// A batch is claimed by postponing its next attempt in a single statement, so no row stays locked while
+// it is published. The rows of a relay stopped before marking them are published again once the claim expires,
+// after twice the publish timeout. The messages fail right away while the publisher is unready
+// and the batch gives up waiting for confirmations after the publish timeout.
+type Relay struct {
+	db        orm.DB
+	publisher *publisher.Publisher
+	logger    logger.Logger
+
+	tableName       string
+	batchSize       int
+	pollInterval    time.Duration
+	publishTimeout  time.Duration
+	maxAttempts     int
+	nextRetryPeriod func(attempts int) time.Duration
+}
+
+// NewRelay returns Relay or a configuration error.
+func NewRelay(db orm.DB, p *publisher.Publisher, opts ...RelayOption) (*Relay, error) {
+	r := &Relay{
+		db:             db,
+		publisher:      p,
+		tableName:      DefaultTableName,
+		batchSize:      100,
+		pollInterval:   time.Second,
+		publishTimeout: time.Second * 30,
+		nextRetryPeriod: func(_ int) time.Duration {
+			return time.Second * 5
+		},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.db == nil {
+		return nil, fmt.Errorf("db must be not nil")
+	}
+
+	if r.publisher == nil {
+		return nil, fmt.Errorf("publisher must be not nil")
+	}
+
+	if !r.publisher.Confirming() {
+		return nil, fmt.Errorf("publisher must be created with confirmation")
+	}
+
+	if r.tableName == "" {
+		return nil, fmt.Errorf("table name must be not empty")
+	}
+
+	if r.batchSize < 1 {
+		return nil, fmt.Errorf("batch size must be greater than zero")
+	}
+
+	if r.pollInterval <= 0 {
+		return nil, fmt.Errorf("poll interval must be greater than zero")
+	}
+
+	if r.publishTimeout <= 0 {
+		return nil, fmt.Errorf("publish timeout must be greater than zero")
+	}
+
+	if r.logger == nil {
+		defaultLogger, err := logging.New()
+		if err != nil {
+			return nil, err
+		}
+		r.logger = defaultLogger
+	}
+
+	return r, nil
+}
+
+// WithRelayTableName configure the outbox table. Default: mediator_outbox.
+func WithRelayTableName(name string) RelayOption {
+	return func(r *Relay) {
+		r.tableName = name
+	}
+}
+
+// WithRelayLogger configure the logger used by Relay
+func WithRelayLogger(l logger.Logger) RelayOption {
+	return func(r *Relay) {
+		r.logger = l
+	}
+}
+
+// WithBatchSize configure how many rows are published per poll. Default: 100.
+func WithBatchSize(size int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = size
+	}
+}
+
+// WithPollInterval configure how much time to wait between polls. Default: 1sec.
+func WithPollInterval(dur time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = dur
+	}
+}
+
+// WithPublishTimeout configure how long a batch waits for the confirmations of its messages. Default: 30sec.
+// The messages not confirmed in time are retried.
+func WithPublishTimeout(dur time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.publishTimeout = dur
+	}
+}
+
+// WithMaxAttempts configure how many times a row is tried before it is left in the table. Default: unlimited.
+func WithMaxAttempts(attempts int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = attempts
+	}
+}
+
+// WithRelayRetryPeriodFunc configure how much time to wait before a failed row is retried. Default: 5sec.
+func WithRelayRetryPeriodFunc(durFunc func(attempts int) time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.nextRetryPeriod = durFunc
+	}
+}
+
+// Run polls the outbox table and publishes pending rows until the context is canceled.
+func (r *Relay) Run(ctx context.Context) error {
+	r.logger.Debug("[DEBUG] outbox relay started")
+	defer r.logger.Debug("[DEBUG] outbox relay stopped")
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		relayed, err := r.relay(ctx)
+		if err != nil {
+			r.logger.Error("[ERROR] outbox relay", err)
+		}
+
+		if relayed == r.batchSize {
+			// The table might still contain pending rows, poll again right away.
+			timer.Reset(0)
+			continue
+		}
+
+		timer.Reset(r.pollInterval)
+	}
+}
+
+// relay publishes one batch of pending rows and returns how many rows were taken.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	rows, err := r.claim(ctx)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+	defer cancel()
+
+	// Publish the whole batch before waiting so confirms are pipelined.
+	resultChs := make([]<-chan error, len(rows))
+	for i, row := range rows {
+		resultChs[i] = r.publisher.Go(publisher.Message{
+			Context:      publishCtx,
+			Exchange:     row.Exchange,
+			Key:          row.RoutingKey,
+			ErrOnUnready: true,
+			ResultCh:     make(chan error, 1),
+			Publishing:   newPublishing(row),
+		})
+	}
+
+	sent := make([]string, 0, len(rows))
+	failed := make(map[int]error)
+	for i, row := range rows {
+		if publishErr := waitResult(publishCtx, resultChs[i]); publishErr != nil {
+			r.logger.Warn("[WARN] outbox relay: publish failed", map[string]interface{}{
+				"id":    row.Id,
+				"error": publishErr.Error(),
+			})
+			failed[i] = publishErr
+			continue
+		}
+
+		sent = append(sent, row.Id)
+	}
+
+	// The rows left claimed by a marking error are published again once the claim expires.
+	if err = r.markSent(ctx, sent); err != nil {
+		return len(rows), err
+	}
+
+	for i, publishErr := range failed {
+		if err = r.markFailed(ctx, rows[i], publishErr); err != nil {
+			return len(rows), err
+		}
+	}
+
+	return len(rows), nil
+}
+
+// claim increments the attempts of a batch of pending rows and postpones their next attempt
+// until the batch is surely published or abandoned.
+func (r *Relay) claim(ctx context.Context) ([]OutboxMessage, error) {
+	var rows []OutboxMessage
+	if _, err := r.db.QueryContext(ctx, &rows, `
+		UPDATE ? SET attempts = attempts + 1, next_attempt_at = ?
+		WHERE id IN (
+			SELECT id
+			FROM ?
+			WHERE sent_at IS NULL AND next_attempt_at <= now() AND (? = 0 OR attempts < ?)
+			ORDER BY created_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, message_type, exchange, routing_key, content_type, payload, attempts, created_at`,
+		pg.Ident(r.tableName), time.Now().UTC().Add(2*r.publishTimeout),
+		pg.Ident(r.tableName), r.maxAttempts, r.maxAttempts, r.batchSize,
+	); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery.
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].CreatedAt.Before(rows[j].CreatedAt)
+	})
+
+	return rows, nil
+}
+
+func (r *Relay) markSent(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := r.db.ExecContext(ctx, `UPDATE ? SET sent_at = now() WHERE id IN (?)`,
+		pg.Ident(r.tableName), pg.In(ids))
+	return err
+}
+
+func (r *Relay) markFailed(ctx context.Context, row OutboxMessage, publishErr error) error {
+	nextAttemptAt := time.Now().UTC().Add(r.nextRetryPeriod(row.Attempts))
+	_, err := r.db.ExecContext(ctx, `UPDATE ? SET last_error = ?, next_attempt_at = ? WHERE id = ?`,
+		pg.Ident(r.tableName), publishErr.Error(), nextAttemptAt, row.Id)
+	return err
+}
+
+// waitResult waits for the publish result until the context is done, a result already received wins.
+func waitResult(ctx context.Context, resultCh <-chan error) error {
+	select {
+	case err := <-resultCh:
+		return err
+	default:
+	}
+
+	select {
+	case err := <-resultCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("confirmation: %v", ctx.Err())
+	}
+}
+
+func newPublishing(row OutboxMessage) amqp.Publishing {
+	return amqp.Publishing{
+		MessageId:    row.Id,
+		Type:         row.MessageType,
+		ContentType:  row.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    row.CreatedAt,
+		Body:         row.Payload,
+	}
+}
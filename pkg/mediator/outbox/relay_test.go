@@ -0,0 +1,226 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/amqptest"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+type (
+	// fakeDB records the statements formatted as they would be sent to PostgreSQL.
+	// The claim query returns the pending rows once. Other methods of orm.DB are not implemented.
+	fakeDB struct {
+		orm.DB
+
+		mu         sync.Mutex
+		pending    []OutboxMessage
+		statements []string
+	}
+
+	nopLogger struct{}
+)
+
+func (db *fakeDB) QueryContext(_ context.Context, model, query interface{}, params ...interface{}) (orm.Result, error) {
+	db.record(query, params)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	*model.(*[]OutboxMessage) = db.pending
+	db.pending = nil
+	return nil, nil
+}
+
+func (db *fakeDB) ExecContext(_ context.Context, query interface{}, params ...interface{}) (orm.Result, error) {
+	db.record(query, params)
+	return nil, nil
+}
+
+func (db *fakeDB) record(query interface{}, params []interface{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	statement := string(orm.NewFormatter().FormatQuery(nil, query.(string), params...))
+	db.statements = append(db.statements, strings.Join(strings.Fields(statement), " "))
+}
+
+func (db *fakeDB) takeStatements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	statements := db.statements
+	db.statements = nil
+	return statements
+}
+
+func (nopLogger) Debug(string, ...map[string]interface{}) {}
+
+func (nopLogger) Info(string, ...map[string]interface{}) {}
+
+func (nopLogger) Warn(string, ...map[string]interface{}) {}
+
+func (nopLogger) Error(string, error, ...map[string]interface{}) {}
+
+func (nopLogger) Fatal(string, error, ...map[string]interface{}) {}
+
+// newPublisher returns a confirming publisher of the fake broker, once it is ready when ready is set.
+func newPublisher(t *testing.T, b *amqptest.Broker, ready bool, opts ...publisher.Option) *publisher.Publisher {
+	t.Helper()
+
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(amqptest.DialerInitFunc),
+		rabbitmq.WithRetryPeriod(10*time.Millisecond),
+		rabbitmq.WithLogger(nopLogger{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err = rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := d.Publisher(append([]publisher.Option{publisher.WithConfirmation(10)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	for stateCh := p.Notify(make(chan publisher.State, 1)); ready; {
+		select {
+		case state := <-stateCh:
+			ready = state.Ready == nil
+		case <-ctx.Done():
+			t.Fatal("publisher is not ready")
+		}
+	}
+
+	return p
+}
+
+func pendingRows() []OutboxMessage {
+	now := time.Now().UTC()
+
+	// The claim returns the rows in any order.
+	return []OutboxMessage{
+		{Id: "b", MessageType: "order.created", RoutingKey: "orders", Payload: []byte(`{}`), Attempts: 1, CreatedAt: now},
+		{Id: "a", MessageType: "order.created", RoutingKey: "orders", Payload: []byte(`{}`), Attempts: 3, CreatedAt: now.Add(-time.Second)},
+	}
+}
+
+func TestRelay_ClaimsPublishesAndMarksRowsSent(t *testing.T) {
+	b := amqptest.NewBroker()
+	db := &fakeDB{pending: pendingRows()}
+
+	r, err := NewRelay(db, newPublisher(t, b, true), WithBatchSize(10), WithMaxAttempts(5), WithRelayLogger(nopLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayed, err := r.relay(context.Background())
+	if err != nil || relayed != 2 {
+		t.Fatalf("relayed %d rows: %v", relayed, err)
+	}
+
+	if n := b.QueueLen("orders"); n != 2 {
+		t.Fatalf("%d messages published, want 2", n)
+	}
+
+	statements := db.takeStatements()
+	if len(statements) != 2 {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+
+	claim := statements[0]
+	for _, want := range []string{`UPDATE "mediator_outbox" SET attempts = attempts + 1`, `(5 = 0 OR attempts < 5)`, `LIMIT 10`, `FOR UPDATE SKIP LOCKED`} {
+		if !strings.Contains(claim, want) {
+			t.Fatalf("claim %q does not contain %q", claim, want)
+		}
+	}
+
+	// The rows are published in creation order and marked sent at once.
+	if want := `UPDATE "mediator_outbox" SET sent_at = now() WHERE id IN ('a','b')`; statements[1] != want {
+		t.Fatalf("unexpected mark %q, want %q", statements[1], want)
+	}
+
+	// Nothing is pending anymore.
+	if relayed, err = r.relay(context.Background()); err != nil || relayed != 0 {
+		t.Fatalf("relayed %d rows: %v", relayed, err)
+	}
+	if statements = db.takeStatements(); len(statements) != 1 {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+}
+
+func TestRelay_MarksUnpublishedRowsFailed(t *testing.T) {
+	b := amqptest.NewBroker()
+	db := &fakeDB{pending: pendingRows()}
+
+	// The publisher never gets a channel.
+	p := newPublisher(t, b, false, publisher.WithInitFunc(func(publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+		return nil, errors.New("channel unavailable")
+	}))
+
+	var retried []int
+	r, err := NewRelay(db, p, WithRelayLogger(nopLogger{}), WithRelayRetryPeriodFunc(func(attempts int) time.Duration {
+		retried = append(retried, attempts)
+		return time.Minute
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if relayed, err := r.relay(context.Background()); err != nil || relayed != 2 {
+		t.Fatalf("relayed %d rows: %v", relayed, err)
+	}
+
+	statements := db.takeStatements()
+	if len(statements) != 3 {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+
+	// Failed rows are retried after the period of their attempts, counted by the claim.
+	failed := strings.Join(statements[1:], "\n")
+	for _, want := range []string{`SET last_error = 'publisher not ready'`, `WHERE id = 'a'`, `WHERE id = 'b'`} {
+		if !strings.Contains(failed, want) {
+			t.Fatalf("failed marks %q do not contain %q", failed, want)
+		}
+	}
+	if len(retried) != 2 || retried[0]+retried[1] != 4 {
+		t.Fatalf("unexpected retry periods of attempts %v", retried)
+	}
+}
+
+func TestNewRelay_RequiresAConfirmingPublisher(t *testing.T) {
+	b := amqptest.NewBroker()
+
+	d, err := rabbitmq.NewDialer(rabbitmq.WithURL("amqp://fake"), rabbitmq.WithAMQPDial(b.Dial), rabbitmq.WithLogger(nopLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	p, err := d.Publisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if _, err = NewRelay(&fakeDB{}, p); err == nil {
+		t.Fatal("expected an error")
+	}
+}
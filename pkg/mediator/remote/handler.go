@@ -0,0 +1,218 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/ereb-or-od/kenobi/pkg/logging"
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer/middleware"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+// TypeHeader is the header used to find the message type when the delivery has no Type property.
+const TypeHeader = "type"
+
+// ErrorHeader is set on replies when the mediator returned an error.
+const ErrorHeader = "x-error"
+
+// Option could be used to configure Handler
+type Option func(h *Handler)
+
+// Handler is a consumer.Handler dispatching deliveries into a mediator.
+// The message type is read from the Type property, the type header or the routing key, in this order.
+// The result is one of the middleware.AckNack outcomes, so the handler must be wrapped with middleware.AckNack().
+type Handler struct {
+	sender     mediator.Sender
+	marshaller interfaces.Marshaller
+	publisher  *publisher.Publisher
+	logger     logger.Logger
+
+	messageTypes map[string]reflect.Type
+	errorResult  func(msg amqp.Delivery, err error) string
+}
+
+// NewHandler returns Handler or a configuration error.
+func NewHandler(sender mediator.Sender, marshaller interfaces.Marshaller, opts ...Option) (*Handler, error) {
+	h := &Handler{
+		sender:       sender,
+		marshaller:   marshaller,
+		messageTypes: make(map[string]reflect.Type),
+		errorResult: func(_ amqp.Delivery, _ error) string {
+			return middleware.Nack
+		},
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.sender == nil {
+		return nil, fmt.Errorf("sender must be not nil")
+	}
+
+	if h.marshaller == nil {
+		return nil, fmt.Errorf("marshaller must be not nil")
+	}
+
+	if len(h.messageTypes) == 0 {
+		return nil, fmt.Errorf("at least one message must be registered")
+	}
+
+	if h.logger == nil {
+		defaultLogger, err := logging.New()
+		if err != nil {
+			return nil, err
+		}
+		h.logger = defaultLogger
+	}
+
+	return h, nil
+}
+
+// WithMessage registers the message type under its Key and the additional names.
+// The message must be a pointer, a new value of the same type is created for every delivery.
+func WithMessage(msg mediator.Message, names ...string) Option {
+	return func(h *Handler) {
+		t := reflect.TypeOf(msg)
+		if t.Kind() != reflect.Ptr {
+			panic("remote: message must be a pointer")
+		}
+
+		h.messageTypes[msg.Key()] = t.Elem()
+		for _, name := range names {
+			h.messageTypes[name] = t.Elem()
+		}
+	}
+}
+
+// WithPublisher configure the publisher used to reply when ReplyTo and CorrelationId are set.
+// Without a publisher no replies are sent.
+func WithPublisher(p *publisher.Publisher) Option {
+	return func(h *Handler) {
+		h.publisher = p
+	}
+}
+
+// WithLogger configure the logger used by Handler
+func WithLogger(l logger.Logger) Option {
+	return func(h *Handler) {
+		h.logger = l
+	}
+}
+
+// WithErrorResult configure the outcome of a delivery the mediator failed to handle. Default: middleware.Nack.
+// No error reply is sent for middleware.Requeue, the caller is replied once the delivery is handled again.
+func WithErrorResult(f func(msg amqp.Delivery, err error) string) Option {
+	return func(h *Handler) {
+		h.errorResult = f
+	}
+}
+
+// Handle implements consumer.Handler.
+// The reply is sent once the outcome is final: requeued deliveries are replied when they are handled again.
+func (h *Handler) Handle(ctx context.Context, msg amqp.Delivery) interface{} {
+	req, err := h.decode(msg)
+	if err != nil {
+		h.logger.Error("[ERROR] remote: decode", err, map[string]interface{}{
+			"messageId":  msg.MessageId,
+			"routingKey": msg.RoutingKey,
+		})
+		h.reply(ctx, msg, nil, err)
+		return middleware.Nack
+	}
+
+	res, err := h.sender.Send(ctx, req)
+	if err != nil {
+		h.logger.Error("[ERROR] remote: send", err, map[string]interface{}{
+			"messageId": msg.MessageId,
+			"type":      req.Key(),
+		})
+
+		result := h.errorResult(msg, err)
+		if result != middleware.Requeue {
+			h.reply(ctx, msg, nil, err)
+		}
+		return result
+	}
+
+	h.reply(ctx, msg, res, nil)
+	return middleware.Ack
+}
+
+func (h *Handler) decode(msg amqp.Delivery) (mediator.Message, error) {
+	name := messageType(msg)
+
+	t, ok := h.messageTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("message type %q is not registered", name)
+	}
+
+	req := reflect.New(t).Interface().(mediator.Message)
+	if err := h.marshaller.Unmarshall(msg.Body, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (h *Handler) reply(ctx context.Context, msg amqp.Delivery, res interface{}, sendErr error) {
+	if h.publisher == nil || msg.ReplyTo == "" || msg.CorrelationId == "" {
+		return
+	}
+
+	publishing := amqp.Publishing{
+		CorrelationId: msg.CorrelationId,
+		ContentType:   msg.ContentType,
+		Headers:       amqp.Table{},
+	}
+
+	if sendErr != nil {
+		publishing.Headers[ErrorHeader] = sendErr.Error()
+	} else {
+		body, err := h.marshaller.Marshall(res)
+		if err != nil {
+			h.logger.Error("[ERROR] remote: marshall reply", err)
+			publishing.Headers[ErrorHeader] = err.Error()
+		} else {
+			publishing.Body = body
+		}
+	}
+
+	if err := h.publisher.Publish(publisher.Message{
+		Context:    ctx,
+		Key:        msg.ReplyTo,
+		Publishing: publishing,
+	}); err != nil {
+		h.logger.Error("[ERROR] remote: publish reply", err, map[string]interface{}{
+			"correlationId": msg.CorrelationId,
+		})
+	}
+}
+
+// ReplyError returns the error carried by a reply published by Handler, if any.
+func ReplyError(msg amqp.Delivery) error {
+	if v, ok := msg.Headers[ErrorHeader]; ok {
+		return fmt.Errorf("%v", v)
+	}
+	return nil
+}
+
+func messageType(msg amqp.Delivery) string {
+	if msg.Type != "" {
+		return msg.Type
+	}
+
+	if v, ok := msg.Headers[TypeHeader].(string); ok && v != "" {
+		return v
+	}
+
+	return msg.RoutingKey
+}
+
+var _ consumer.Handler = (*Handler)(nil)
@@ -0,0 +1,189 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/json"
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/amqptest"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer/middleware"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+type (
+	createOrder struct {
+		Id string `json:"id"`
+	}
+
+	orderCreated struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	// orderSender creates the orders, except the ones with id fail.
+	orderSender struct{}
+
+	nopLogger struct{}
+)
+
+func (*createOrder) Key() string {
+	return "create.order"
+}
+
+func (orderSender) Send(_ context.Context, msg mediator.Message) (interface{}, error) {
+	req := msg.(*createOrder)
+	if req.Id == "fail" {
+		return nil, fmt.Errorf("order %s rejected", req.Id)
+	}
+	return orderCreated{Id: req.Id, Status: "created"}, nil
+}
+
+func (nopLogger) Debug(string, ...map[string]interface{}) {}
+
+func (nopLogger) Info(string, ...map[string]interface{}) {}
+
+func (nopLogger) Warn(string, ...map[string]interface{}) {}
+
+func (nopLogger) Error(string, error, ...map[string]interface{}) {}
+
+func (nopLogger) Fatal(string, error, ...map[string]interface{}) {}
+
+// startHandler consumes the commands queue of the fake broker with Handler and returns an RPC client calling it.
+func startHandler(t *testing.T, b *amqptest.Broker) *rabbitmq.RPCClient {
+	t.Helper()
+
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(amqptest.DialerInitFunc),
+		rabbitmq.WithRetryPeriod(10*time.Millisecond),
+		rabbitmq.WithLogger(nopLogger{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err = rabbitmq.Queue(ctx, d, "commands", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	replier, err := d.Publisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(replier.Close)
+
+	h, err := NewHandler(orderSender{}, json.New(), WithMessage(&createOrder{}), WithPublisher(replier), WithLogger(nopLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateCh := make(chan consumer.State, 1)
+	c, err := d.Consumer(
+		consumer.WithQueue("commands"),
+		consumer.WithHandler(consumer.Wrap(h, middleware.AckNack())),
+		consumer.WithNotify(stateCh),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	for ready := false; !ready; {
+		select {
+		case state := <-stateCh:
+			ready = state.Ready != nil
+		case <-ctx.Done():
+			t.Fatal("consumer is not ready")
+		}
+	}
+
+	client, err := rabbitmq.NewRPCClient(d, rabbitmq.WithRPCPublisherOptions(publisher.WithConfirmation(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+func call(t *testing.T, client *rabbitmq.RPCClient, msgType string, body string) amqp.Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := client.Call(ctx, publisher.Message{
+		Key:        "commands",
+		Publishing: amqp.Publishing{Type: msgType, ContentType: "application/json", Body: []byte(body)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestHandler_RepliesWithTheResult(t *testing.T) {
+	client := startHandler(t, amqptest.NewBroker())
+
+	reply := call(t, client, "create.order", `{"id":"1"}`)
+	if err := ReplyError(reply); err != nil {
+		t.Fatal(err)
+	}
+
+	var res orderCreated
+	if err := json.New().Unmarshall(reply.Body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Id != "1" || res.Status != "created" {
+		t.Fatalf("unexpected reply %s", reply.Body)
+	}
+}
+
+func TestHandler_RepliesWithTheErrorHeader(t *testing.T) {
+	b := amqptest.NewBroker()
+	client := startHandler(t, b)
+
+	reply := call(t, client, "create.order", `{"id":"fail"}`)
+	if err := ReplyError(reply); err == nil || err.Error() != "order fail rejected" {
+		t.Fatalf("unexpected reply error %v", err)
+	}
+	if len(reply.Body) != 0 {
+		t.Fatalf("unexpected reply body %s", reply.Body)
+	}
+
+	// Unknown types fail to decode.
+	reply = call(t, client, "cancel.order", `{"id":"1"}`)
+	if err := ReplyError(reply); err == nil || err.Error() != `message type "cancel.order" is not registered` {
+		t.Fatalf("unexpected reply error %v", err)
+	}
+
+	// The failed deliveries are nacked without requeue, right after the reply is published.
+	timeout := time.After(time.Second)
+	for b.QueueLen("commands")+b.Unacked("commands") != 0 {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("%d failed deliveries left in the queue", b.QueueLen("commands")+b.Unacked("commands"))
+		}
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	if err := ReplyError(amqp.Delivery{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := ReplyError(amqp.Delivery{Headers: amqp.Table{ErrorHeader: "boom"}}); err == nil || err.Error() != "boom" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package saga
+
+import (
+	"context"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/mongodb/interfaces"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type mongodbStore struct {
+	db interfaces.MongoDbDatabase
+}
+
+// NewMongoDbStore returns a Store keeping saga states in the collection of the given database.
+func NewMongoDbStore(db interfaces.MongoDbDatabase) Store {
+	return &mongodbStore{
+		db: db,
+	}
+}
+
+func (s mongodbStore) Save(ctx context.Context, state *State) error {
+	saved := *state
+	saved.Version++
+
+	if state.Version == 0 {
+		if err := s.db.Insert(ctx, &saved); err != nil {
+			return err
+		}
+
+		state.Version = saved.Version
+		return nil
+	}
+
+	matched, err := s.db.ReplaceOne(ctx, bson.M{"_id": state.Id, "version": state.Version}, &saved)
+	if err != nil {
+		return err
+	}
+
+	if matched == 0 {
+		return ErrConcurrentUpdate
+	}
+
+	state.Version = saved.Version
+	return nil
+}
+
+func (s mongodbStore) FindUnfinished(ctx context.Context, updatedBefore time.Time) ([]*State, error) {
+	var states []*State
+	if err := s.db.FindAll(ctx, bson.M{
+		"status":     bson.M{"$in": []Status{Running, Compensating}},
+		"updated_at": bson.M{"$lt": updatedBefore},
+	}, bson.M{"created_at": 1}, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
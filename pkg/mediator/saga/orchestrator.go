@@ -0,0 +1,385 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/hub"
+	"github.com/ereb-or-od/kenobi/pkg/logging"
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+	"github.com/google/uuid"
+)
+
+// Progress events are published on the hub as `saga.<definition name>.<event>`.
+const (
+	StartedEvent            = "started"
+	StepCompletedEvent      = "step.completed"
+	StepFailedEvent         = "step.failed"
+	StepCompensatedEvent    = "step.compensated"
+	CompensationFailedEvent = "compensation.failed"
+	CompletedEvent          = "completed"
+	CompensatedEvent        = "compensated"
+)
+
+// Option could be used to configure Orchestrator
+type Option func(o *Orchestrator)
+
+// Orchestrator executes saga definitions through a mediator and persists their progress in a Store.
+type Orchestrator struct {
+	sender     mediator.Sender
+	store      Store
+	marshaller interfaces.Marshaller
+	hub        *hub.Hub
+	logger     logger.Logger
+
+	definitions      map[string]*Definition
+	recoveryInterval time.Duration
+	staleAfter       time.Duration
+
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+// New returns Orchestrator or a configuration error.
+func New(sender mediator.Sender, store Store, marshaller interfaces.Marshaller, opts ...Option) (*Orchestrator, error) {
+	o := &Orchestrator{
+		sender:           sender,
+		store:            store,
+		marshaller:       marshaller,
+		definitions:      make(map[string]*Definition),
+		recoveryInterval: time.Second * 30,
+		staleAfter:       time.Minute,
+		running:          make(map[string]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.sender == nil {
+		return nil, fmt.Errorf("sender must be not nil")
+	}
+
+	if o.store == nil {
+		return nil, fmt.Errorf("store must be not nil")
+	}
+
+	if o.marshaller == nil {
+		return nil, fmt.Errorf("marshaller must be not nil")
+	}
+
+	for name, def := range o.definitions {
+		if name == "" {
+			return nil, fmt.Errorf("definition name must be not empty")
+		}
+
+		if len(def.Steps) == 0 {
+			return nil, fmt.Errorf("definition %s: steps must be not empty", name)
+		}
+
+		for _, step := range def.Steps {
+			if step.Command == nil {
+				return nil, fmt.Errorf("definition %s: step %s: command must be not nil", name, step.Name)
+			}
+		}
+	}
+
+	if o.recoveryInterval <= 0 {
+		return nil, fmt.Errorf("recovery interval must be greater than zero")
+	}
+
+	if o.logger == nil {
+		defaultLogger, err := logging.New()
+		if err != nil {
+			return nil, err
+		}
+		o.logger = defaultLogger
+	}
+
+	return o, nil
+}
+
+// WithDefinition registers a saga definition.
+func WithDefinition(def *Definition) Option {
+	return func(o *Orchestrator) {
+		o.definitions[def.Name] = def
+	}
+}
+
+// WithHub configure the hub progress events are published on.
+func WithHub(h *hub.Hub) Option {
+	return func(o *Orchestrator) {
+		o.hub = h
+	}
+}
+
+// WithLogger configure the logger used by Orchestrator
+func WithLogger(l logger.Logger) Option {
+	return func(o *Orchestrator) {
+		o.logger = l
+	}
+}
+
+// WithRecoveryInterval configure how much time to wait between recoveries. Default: 30sec.
+func WithRecoveryInterval(dur time.Duration) Option {
+	return func(o *Orchestrator) {
+		o.recoveryInterval = dur
+	}
+}
+
+// WithStaleAfter configure how long a saga must be idle before recovery resumes it. Default: 1min.
+// It prevents resuming sagas that are still executed by another instance, so it must be longer than any step.
+// A saga resumed while its step is still running is stopped at its next save by ErrConcurrentUpdate.
+func WithStaleAfter(dur time.Duration) Option {
+	return func(o *Orchestrator) {
+		o.staleAfter = dur
+	}
+}
+
+// Start creates a saga instance of the named definition and executes it.
+// The saga id is returned even if the saga failed, the error tells why it was compensated or interrupted.
+// ErrConcurrentUpdate tells the saga was resumed by the recovery of another instance.
+func (o *Orchestrator) Start(ctx context.Context, name string, data Data) (string, error) {
+	def, ok := o.definitions[name]
+	if !ok {
+		return "", fmt.Errorf("saga definition %s could not be found", name)
+	}
+
+	if data == nil {
+		data = Data{}
+	}
+
+	payload, err := o.marshaller.Marshall(data)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	state := &State{
+		Id:        uuid.NewString(),
+		Name:      name,
+		Status:    Running,
+		Data:      payload,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err = o.store.Save(ctx, state); err != nil {
+		return "", err
+	}
+
+	o.publish(state, StartedEvent, nil)
+
+	return state.Id, o.run(ctx, def, state, false)
+}
+
+// Recover resumes every unfinished saga which was idle for longer than the stale period.
+// A saga is claimed by saving it first, so it is resumed by a single instance.
+func (o *Orchestrator) Recover(ctx context.Context) error {
+	states, err := o.store.FindUnfinished(ctx, time.Now().UTC().Add(-o.staleAfter))
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		def, ok := o.definitions[state.Name]
+		if !ok {
+			o.logger.Warn("[WARN] saga: definition not registered", map[string]interface{}{
+				"id":   state.Id,
+				"name": state.Name,
+			})
+			continue
+		}
+
+		if err = o.run(ctx, def, state, true); err != nil {
+			o.logger.Error("[ERROR] saga: recover", err, map[string]interface{}{
+				"id":   state.Id,
+				"name": state.Name,
+			})
+		}
+	}
+
+	return nil
+}
+
+// RunRecovery calls Recover every recovery interval until the context is canceled.
+func (o *Orchestrator) RunRecovery(ctx context.Context) {
+	ticker := time.NewTicker(o.recoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.Recover(ctx); err != nil {
+			o.logger.Error("[ERROR] saga: recovery", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *Orchestrator) run(ctx context.Context, def *Definition, state *State, claim bool) error {
+	if !o.acquire(state.Id) {
+		return nil
+	}
+	defer o.release(state.Id)
+
+	if claim {
+		state.UpdatedAt = time.Now().UTC()
+		if err := o.store.Save(ctx, state); errors.Is(err, ErrConcurrentUpdate) {
+			o.logger.Debug("[DEBUG] saga: claimed by another instance", map[string]interface{}{
+				"id":   state.Id,
+				"name": state.Name,
+			})
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	data := Data{}
+	if err := o.marshaller.Unmarshall(state.Data, &data); err != nil {
+		return err
+	}
+
+	if state.Status == Running {
+		if err := o.execute(ctx, def, state, data); err != nil {
+			return err
+		}
+	}
+
+	if state.Status == Compensating {
+		return o.compensate(ctx, def, state, data)
+	}
+
+	return nil
+}
+
+func (o *Orchestrator) execute(ctx context.Context, def *Definition, state *State, data Data) error {
+	for state.CurrentStep < len(def.Steps) {
+		step := def.Steps[state.CurrentStep]
+
+		result, err := o.sender.Send(ctx, step.Command(data))
+		if err != nil {
+			o.publish(state, StepFailedEvent, hub.Fields{"step": step.Name, "error": err.Error()})
+
+			state.Status = Compensating
+			state.Error = err.Error()
+			if saveErr := o.save(ctx, state, data); saveErr != nil {
+				return saveErr
+			}
+
+			return nil
+		}
+
+		if step.OnResult != nil {
+			step.OnResult(data, result)
+		}
+
+		state.CurrentStep++
+		if state.CurrentStep == len(def.Steps) {
+			state.Status = Completed
+		}
+
+		if err = o.save(ctx, state, data); err != nil {
+			return err
+		}
+
+		o.publish(state, StepCompletedEvent, hub.Fields{"step": step.Name})
+	}
+
+	o.publish(state, CompletedEvent, nil)
+
+	return nil
+}
+
+func (o *Orchestrator) compensate(ctx context.Context, def *Definition, state *State, data Data) error {
+	for state.CurrentStep > 0 {
+		step := def.Steps[state.CurrentStep-1]
+
+		if step.Compensation != nil {
+			if _, err := o.sender.Send(ctx, step.Compensation(data)); err != nil {
+				// The saga stays in Compensating status and is retried by the recovery.
+				o.publish(state, CompensationFailedEvent, hub.Fields{"step": step.Name, "error": err.Error()})
+				return err
+			}
+		}
+
+		state.CurrentStep--
+		if state.CurrentStep == 0 {
+			state.Status = Compensated
+		}
+
+		if err := o.save(ctx, state, data); err != nil {
+			return err
+		}
+
+		o.publish(state, StepCompensatedEvent, hub.Fields{"step": step.Name})
+	}
+
+	if state.Status != Compensated {
+		// The first step failed, there was nothing to compensate.
+		state.Status = Compensated
+		if err := o.save(ctx, state, data); err != nil {
+			return err
+		}
+	}
+
+	o.publish(state, CompensatedEvent, hub.Fields{"error": state.Error})
+
+	return fmt.Errorf("saga %s compensated: %s", state.Id, state.Error)
+}
+
+func (o *Orchestrator) save(ctx context.Context, state *State, data Data) error {
+	payload, err := o.marshaller.Marshall(data)
+	if err != nil {
+		return err
+	}
+
+	state.Data = payload
+	state.UpdatedAt = time.Now().UTC()
+
+	return o.store.Save(ctx, state)
+}
+
+func (o *Orchestrator) publish(state *State, event string, fields hub.Fields) {
+	if o.hub == nil {
+		return
+	}
+
+	if fields == nil {
+		fields = hub.Fields{}
+	}
+	fields["id"] = state.Id
+	fields["status"] = string(state.Status)
+
+	o.hub.Publish(hub.Message{
+		Name:   "saga." + state.Name + "." + event,
+		Fields: fields,
+	})
+}
+
+func (o *Orchestrator) acquire(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.running[id]; ok {
+		return false
+	}
+	o.running[id] = struct{}{}
+
+	return true
+}
+
+func (o *Orchestrator) release(id string) {
+	o.mu.Lock()
+	delete(o.running, id)
+	o.mu.Unlock()
+}
@@ -0,0 +1,276 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+)
+
+type (
+	memoryStore struct {
+		mu     sync.Mutex
+		states map[string]State
+		// beforeSave is called with the saved state before its version is checked.
+		beforeSave func(state *State)
+	}
+
+	command string
+
+	recordingSender struct {
+		mu      sync.Mutex
+		sent    []string
+		failing map[string]bool
+	}
+
+	stdMarshaller struct{}
+)
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{states: make(map[string]State)}
+}
+
+func (s *memoryStore) Save(_ context.Context, state *State) error {
+	if s.beforeSave != nil {
+		s.beforeSave(state)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saved, ok := s.states[state.Id]; ok != (state.Version != 0) || saved.Version != state.Version {
+		return ErrConcurrentUpdate
+	}
+
+	state.Version++
+	s.states[state.Id] = *state
+	return nil
+}
+
+func (s *memoryStore) FindUnfinished(_ context.Context, updatedBefore time.Time) ([]*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*State
+	for _, state := range s.states {
+		if !state.Finished() && state.UpdatedAt.Before(updatedBefore) {
+			state := state
+			result = append(result, &state)
+		}
+	}
+	return result, nil
+}
+
+func (s *memoryStore) get(id string) State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[id]
+}
+
+func (c command) Key() string {
+	return string(c)
+}
+
+func (s *recordingSender) Send(_ context.Context, msg mediator.Message) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, msg.Key())
+	if s.failing[msg.Key()] {
+		return nil, fmt.Errorf("%s failed", msg.Key())
+	}
+	return msg.Key() + " result", nil
+}
+
+func (s *recordingSender) commands() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprint(s.sent)
+}
+
+func (stdMarshaller) Marshall(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (stdMarshaller) Unmarshall(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (stdMarshaller) MarshallString(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func (stdMarshaller) UnmarshallString(data string, v interface{}) error {
+	return json.Unmarshal([]byte(data), v)
+}
+
+func orderDefinition() *Definition {
+	step := func(name string) Step {
+		return Step{
+			Name:         name,
+			Command:      func(Data) mediator.Message { return command(name) },
+			Compensation: func(Data) mediator.Message { return command("undo " + name) },
+		}
+	}
+
+	def := &Definition{Name: "order", Steps: []Step{step("reserve"), step("charge"), step("ship")}}
+	def.Steps[0].OnResult = func(data Data, result interface{}) {
+		data["reservation"] = result
+	}
+	def.Steps[1].Command = func(data Data) mediator.Message {
+		return command(fmt.Sprintf("charge %v", data["reservation"]))
+	}
+	return def
+}
+
+func newOrchestrator(t *testing.T, store Store, sender mediator.Sender, opts ...Option) *Orchestrator {
+	t.Helper()
+
+	o, err := New(sender, store, stdMarshaller{}, append([]Option{WithDefinition(orderDefinition())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestNew_RejectsDefinitionsWithoutSteps(t *testing.T) {
+	_, err := New(&recordingSender{}, newMemoryStore(), stdMarshaller{}, WithDefinition(&Definition{Name: "empty"}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestOrchestrator_ExecutesEveryStep(t *testing.T) {
+	store, sender := newMemoryStore(), &recordingSender{}
+	o := newOrchestrator(t, store, sender)
+
+	id, err := o.Start(context.Background(), "order", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sender.commands(); got != "[reserve charge reserve result ship]" {
+		t.Fatalf("unexpected commands %s", got)
+	}
+
+	state := store.get(id)
+	if state.Status != Completed || state.CurrentStep != 3 || state.Version != 4 {
+		t.Fatalf("unexpected state %s step %d version %d", state.Status, state.CurrentStep, state.Version)
+	}
+}
+
+func TestOrchestrator_CompensatesCompletedStepsInReverseOrder(t *testing.T) {
+	store, sender := newMemoryStore(), &recordingSender{failing: map[string]bool{"ship": true}}
+	o := newOrchestrator(t, store, sender)
+
+	id, err := o.Start(context.Background(), "order", nil)
+	if err == nil {
+		t.Fatal("expected the saga to be compensated")
+	}
+
+	if got := sender.commands(); got != "[reserve charge reserve result ship undo charge undo reserve]" {
+		t.Fatalf("unexpected commands %s", got)
+	}
+
+	state := store.get(id)
+	if state.Status != Compensated || state.CurrentStep != 0 || state.Error != "ship failed" {
+		t.Fatalf("unexpected state %s step %d error %q", state.Status, state.CurrentStep, state.Error)
+	}
+}
+
+func TestOrchestrator_RecoversStaleSagas(t *testing.T) {
+	store, sender := newMemoryStore(), &recordingSender{}
+	o := newOrchestrator(t, store, sender, WithStaleAfter(time.Minute))
+
+	stale := time.Now().UTC().Add(-time.Hour)
+	for _, state := range []*State{
+		{Id: "running", Name: "order", Status: Running, CurrentStep: 2, Data: []byte(`{}`), UpdatedAt: stale},
+		{Id: "compensating", Name: "order", Status: Compensating, CurrentStep: 1, Data: []byte(`{}`), UpdatedAt: stale},
+		{Id: "recent", Name: "order", Status: Running, CurrentStep: 0, Data: []byte(`{}`), UpdatedAt: time.Now().UTC()},
+	} {
+		if err := store.Save(context.Background(), state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := o.Recover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := store.get("running"); state.Status != Completed {
+		t.Fatalf("running saga is %s", state.Status)
+	}
+	if state := store.get("compensating"); state.Status != Compensated {
+		t.Fatalf("compensating saga is %s", state.Status)
+	}
+	if state := store.get("recent"); state.Status != Running || state.Version != 1 {
+		t.Fatalf("recent saga was resumed: %s version %d", state.Status, state.Version)
+	}
+
+	// Commands of the two stale sagas, in whichever order they were found.
+	if got := sender.commands(); got != "[ship undo reserve]" && got != "[undo reserve ship]" {
+		t.Fatalf("unexpected commands %s", got)
+	}
+}
+
+func TestOrchestrator_SkipsSagasClaimedByAnotherInstance(t *testing.T) {
+	store, sender := newMemoryStore(), &recordingSender{}
+	o := newOrchestrator(t, store, sender, WithStaleAfter(time.Minute))
+
+	if err := store.Save(context.Background(), &State{
+		Id: "stale", Name: "order", Status: Running, Data: []byte(`{}`), UpdatedAt: time.Now().UTC().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance claims the saga between the query and the claim.
+	store.beforeSave = func(*State) {
+		store.beforeSave = nil
+		claimed := store.get("stale")
+		if err := store.Save(context.Background(), &claimed); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := o.Recover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sender.commands(); got != "[]" {
+		t.Fatalf("claimed saga was executed: %s", got)
+	}
+	if state := store.get("stale"); state.Status != Running || state.Version != 2 {
+		t.Fatalf("unexpected state %s version %d", state.Status, state.Version)
+	}
+}
+
+func TestOrchestrator_StopsWhenTheSagaIsResumedByAnotherInstance(t *testing.T) {
+	store, sender := newMemoryStore(), &recordingSender{}
+	o := newOrchestrator(t, store, sender)
+
+	// The recovery of another instance saves the saga while its first step is executed.
+	saves := 0
+	store.beforeSave = func(state *State) {
+		if saves++; saves == 2 {
+			claimed := store.get(state.Id)
+			if err := store.Save(context.Background(), &claimed); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	_, err := o.Start(context.Background(), "order", nil)
+	if !errors.Is(err, ErrConcurrentUpdate) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if got := sender.commands(); got != "[reserve]" {
+		t.Fatalf("unexpected commands %s", got)
+	}
+}
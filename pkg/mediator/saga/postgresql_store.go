@@ -0,0 +1,109 @@
+package saga
+
+import (
+	"context"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/postgresql/interfaces"
+	"github.com/go-pg/pg/v10"
+)
+
+// DefaultTableName is the saga table used by the PostgreSQL store when no table name is given.
+const DefaultTableName = "sagas"
+
+type postgresqlStore struct {
+	db        interfaces.PostgreSqlDatabaseProvider
+	tableName string
+}
+
+// NewPostgreSqlStore returns a Store keeping saga states in the given PostgreSQL table.
+func NewPostgreSqlStore(db interfaces.PostgreSqlDatabaseProvider, tableName string) Store {
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+
+	return &postgresqlStore{
+		db:        db,
+		tableName: tableName,
+	}
+}
+
+// CreateTable creates the saga table and its unfinished sagas index if they do not exist.
+func CreateTable(ctx context.Context, db interfaces.PostgreSqlDatabaseProvider, tableName string) error {
+	if _, err := db.Execute(ctx, `
+		CREATE TABLE IF NOT EXISTS ? (
+			id           uuid PRIMARY KEY,
+			name         text NOT NULL,
+			status       text NOT NULL,
+			current_step integer NOT NULL,
+			data         bytea,
+			error        text,
+			created_at   timestamptz NOT NULL,
+			updated_at   timestamptz NOT NULL,
+			version      integer NOT NULL
+		)`, pg.Ident(tableName)); err != nil {
+		return err
+	}
+
+	if _, err := db.Execute(ctx, `
+		CREATE INDEX IF NOT EXISTS ? ON ? (updated_at) WHERE status IN ('running', 'compensating')`,
+		pg.Ident(tableName+"_unfinished_idx"), pg.Ident(tableName)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s postgresqlStore) Save(ctx context.Context, state *State) error {
+	if state.Version == 0 {
+		if _, err := s.db.Execute(ctx, `
+			INSERT INTO ? (id, name, status, current_step, data, error, created_at, updated_at, version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)`,
+			pg.Ident(s.tableName),
+			state.Id, state.Name, string(state.Status), state.CurrentStep, state.Data, state.Error, state.CreatedAt, state.UpdatedAt,
+		); err != nil {
+			return err
+		}
+
+		state.Version = 1
+		return nil
+	}
+
+	affected, err := s.db.Execute(ctx, `
+		UPDATE ? SET
+			status = ?,
+			current_step = ?,
+			data = ?,
+			error = ?,
+			updated_at = ?,
+			version = version + 1
+		WHERE id = ? AND version = ?`,
+		pg.Ident(s.tableName),
+		string(state.Status), state.CurrentStep, state.Data, state.Error, state.UpdatedAt,
+		state.Id, state.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrConcurrentUpdate
+	}
+
+	state.Version++
+	return nil
+}
+
+func (s postgresqlStore) FindUnfinished(ctx context.Context, updatedBefore time.Time) ([]*State, error) {
+	var states []*State
+	if err := s.db.FindByFilter(ctx, &states, `
+		SELECT id, name, status, current_step, data, error, created_at, updated_at, version
+		FROM ?
+		WHERE status IN (?, ?) AND updated_at < ?
+		ORDER BY created_at`,
+		pg.Ident(s.tableName), string(Running), string(Compensating), updatedBefore,
+	); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -0,0 +1,80 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/mediator"
+)
+
+// Status is the lifecycle status of a saga.
+type Status string
+
+const (
+	// Running sagas are executing their steps.
+	Running Status = "running"
+	// Compensating sagas had a failing step and are executing compensations of the completed steps.
+	Compensating Status = "compensating"
+	// Completed sagas executed all their steps.
+	Completed Status = "completed"
+	// Compensated sagas had a failing step and compensated all the completed steps.
+	Compensated Status = "compensated"
+)
+
+// ErrConcurrentUpdate is returned by Store.Save when the state was saved by someone else since it was read.
+var ErrConcurrentUpdate = errors.New("saga: state was updated concurrently")
+
+type (
+	// Data is the saga payload shared between steps. It is persisted with the saga state,
+	// so the values must survive a round trip through the configured Marshaller.
+	Data map[string]interface{}
+
+	// Step is a mediator command and its compensating command.
+	// Commands may be sent again after a restart, so their handlers must be idempotent.
+	Step struct {
+		Name string
+		// Command builds the command executing the step.
+		Command func(data Data) mediator.Message
+		// Compensation builds the command undoing the step. Steps without compensation are skipped when compensating.
+		Compensation func(data Data) mediator.Message
+		// OnResult could be used to store the command result into the saga data for the next steps.
+		OnResult func(data Data, result interface{})
+	}
+
+	// Definition describes the ordered steps of a saga.
+	Definition struct {
+		Name  string
+		Steps []Step
+	}
+
+	// State is the persisted state of a saga instance.
+	// For Running sagas CurrentStep is the next step to execute, for Compensating sagas
+	// it is the number of completed steps that still have to be compensated.
+	// Version is incremented by every save, 0 for a state that was never saved.
+	State struct {
+		Id          string    `pg:"id" bson:"_id"`
+		Name        string    `pg:"name" bson:"name"`
+		Status      Status    `pg:"status" bson:"status"`
+		CurrentStep int       `pg:"current_step,use_zero" bson:"current_step"`
+		Data        []byte    `pg:"data" bson:"data"`
+		Error       string    `pg:"error" bson:"error"`
+		CreatedAt   time.Time `pg:"created_at" bson:"created_at"`
+		UpdatedAt   time.Time `pg:"updated_at" bson:"updated_at"`
+		Version     int       `pg:"version,use_zero" bson:"version"`
+	}
+
+	// Store persists saga states.
+	Store interface {
+		// Save inserts a state of version 0, or replaces the state saved with the same version,
+		// otherwise it returns ErrConcurrentUpdate. The version of the state is incremented on success.
+		Save(ctx context.Context, state *State) error
+		// FindUnfinished returns Running and Compensating sagas last updated before the given time.
+		FindUnfinished(ctx context.Context, updatedBefore time.Time) ([]*State, error)
+	}
+)
+
+// Finished reports whether the saga reached a final status.
+func (s *State) Finished() bool {
+	return s.Status == Completed || s.Status == Compensated
+}
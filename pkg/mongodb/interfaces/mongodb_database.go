@@ -13,6 +13,10 @@ type MongoDbDatabase  interface {
 	DeleteOneById(ctx context.Context, id string) error
 	DeleteOneByFilter(ctx context.Context, condition string, params ...interface{}) error
 	DeleteAllByFilter(ctx context.Context, condition string, params ...interface{}) error
+	// ReplaceOne replaces the document matching the filter and returns the number of matched documents.
+	ReplaceOne(ctx context.Context, filter interface{}, entity interface{}) (int64, error)
+	// FindAll decodes the documents matching the filter into entities, a pointer to a slice, ordered by sort if not nil.
+	FindAll(ctx context.Context, filter interface{}, sort interface{}, entities interface{}) error
 }
 
 
@@ -117,6 +117,29 @@ func (m mongodbDatabase) DeleteAllByFilter(ctx context.Context, condition string
 	}
 }
 
+func (m mongodbDatabase) ReplaceOne(ctx context.Context, filter interface{}, entity interface{}) (int64, error) {
+	result, err := m.db.ReplaceOne(ctx, filter, entity)
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
+
+func (m mongodbDatabase) FindAll(ctx context.Context, filter interface{}, sort interface{}, entities interface{}) error {
+	opts := options.Find()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+
+	cursor, err := m.db.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return cursor.All(ctx, entities)
+}
+
 func New(connectionString string, database string, collection string) (interfaces.MongoDbDatabase, error) {
 	if client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connectionString)); err != nil {
 		return nil, err
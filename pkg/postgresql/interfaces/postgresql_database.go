@@ -13,6 +13,8 @@ type PostgreSqlDatabaseProvider interface {
 	DeleteOneById(ctx context.Context, id string, entity interface{}) error
 	DeleteOneByFilter(ctx context.Context, entity interface{}, condition string, params ...interface{}) error
 	DeleteAllByFilter(ctx context.Context, entity interface{}, condition string, params ...interface{}) error
+	// Execute runs the statement and returns the number of affected rows.
+	Execute(ctx context.Context, query string, params ...interface{}) (int, error)
 }
 
 
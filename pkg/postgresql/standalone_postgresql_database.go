@@ -12,7 +12,7 @@ type standalonePostgresqlDatabase struct {
 }
 
 func (s standalonePostgresqlDatabase) FindByFilter(ctx context.Context, entity interface{}, query string, params ...interface{}) error {
-	if result, err := s.db.Query(entity, query, params); err != nil {
+	if result, err := s.db.QueryContext(ctx, entity, query, params...); err != nil {
 		return err
 	} else {
 		result.RowsAffected()
@@ -90,6 +90,14 @@ func (s standalonePostgresqlDatabase) Insert(ctx context.Context, entity interfa
 	return nil
 }
 
+func (s standalonePostgresqlDatabase) Execute(ctx context.Context, query string, params ...interface{}) (int, error) {
+	result, err := s.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 func New(options *options.PostgreSqlServerOptions) interfaces.PostgreSqlDatabaseProvider {
 	db := pg.Connect(&pg.Options{
 		User:            options.User,
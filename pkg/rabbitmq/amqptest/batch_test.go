@@ -0,0 +1,98 @@
+package amqptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+func TestPublisher_PublishBatchMatchesReturnsByMessageId(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if _, err := rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := d.Publisher(publisher.WithConfirmation(10), publisher.WithRestartSleep(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for stateCh, ready := p.Notify(make(chan publisher.State, 1)), false; !ready; {
+		select {
+		case state := <-stateCh:
+			ready = state.Ready != nil
+		case <-ctx.Done():
+			t.Fatal("publisher is not ready")
+		}
+	}
+
+	// The unroutable messages are returned, the second one gets a generated id.
+	msgs := []publisher.Message{
+		{Key: "orders", Mandatory: true, Publishing: amqp.Publishing{MessageId: "1"}},
+		{Key: "payments", Mandatory: true, Publishing: amqp.Publishing{MessageId: "2"}},
+		{Key: "payments", Mandatory: true},
+		{Key: "payments"},
+		{Key: "orders"},
+	}
+
+	results, err := p.PublishBatch(ctx, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(msgs) {
+		t.Fatalf("%d results, want %d", len(results), len(msgs))
+	}
+
+	for i, r := range results {
+		if r.DeliveryTag != uint64(i+1) {
+			t.Fatalf("result %d has the delivery tag %d", i, r.DeliveryTag)
+		}
+
+		returned := i == 1 || i == 2
+		if returned != errors.Is(r.Err, publisher.ErrReturned) || returned != (r.Returned != nil) {
+			t.Fatalf("unexpected result %d: %v %v", i, r.Err, r.Returned)
+		}
+		if !returned && r.Err != nil {
+			t.Fatalf("unexpected result %d: %v", i, r.Err)
+		}
+	}
+
+	if id := results[1].Returned.MessageId; id != "2" {
+		t.Fatalf("unexpected returned message %s", id)
+	}
+	if id := results[2].Returned.MessageId; id == "" || id == "2" {
+		t.Fatalf("unexpected returned message %q", id)
+	}
+
+	// The messages of the caller are not changed.
+	if msgs[2].Publishing.MessageId != "" || msgs[2].ResultCh != nil {
+		t.Fatal("the batch messages were changed")
+	}
+
+	if n := b.QueueLen("orders"); n != 2 {
+		t.Fatalf("%d messages in the queue, want 2", n)
+	}
+}
+
+func TestPublisher_PublishBatchRequiresConfirmation(t *testing.T) {
+	p, err := newDialer(t, NewBroker()).Publisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if _, err = p.PublishBatch(context.Background(), []publisher.Message{{Key: "orders"}}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,507 @@
+package amqptest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+type (
+	// Broker is an in-process fake of a RabbitMQ server. It supports direct, topic and fanout exchanges,
+	// queues, bindings, acks and nacks, QoS, publisher confirms, mandatory returns, dead-lettering
+	// and the x-message-ttl queue argument.
+	// Its Dial method could be passed to rabbitmq.WithAMQPDial along with DialerInitFunc to rabbitmq.WithInitFunc,
+	// the consumers and publishers of the Dialer then open their channels on Broker too.
+	// The consumers and publishers created without Dialer open them with ConsumerInitFunc and PublisherInitFunc.
+	// The topologies of rabbitmq.WithTopology are declared on Broker once DialerInitFunc is set.
+	Broker struct {
+		mu sync.Mutex
+
+		exchanges map[string]*exchange
+		queues    map[string]*queue
+		conns     map[*Connection]struct{}
+
+		dialErr error
+		nextID  int
+	}
+
+	exchange struct {
+		name     string
+		kind     string
+		internal bool
+		bindings []binding
+	}
+
+	binding struct {
+		destination string
+		toExchange  bool
+		key         string
+	}
+
+	queue struct {
+		name       string
+		durable    bool
+		autoDelete bool
+		exclusive  bool
+		args       amqp.Table
+		owner      *Connection
+
+		messages    []*message
+		consumers   []*queueConsumer
+		next        int
+		hadConsumer bool
+	}
+
+	message struct {
+		exchange    string
+		key         string
+		publishing  amqp.Publishing
+		redelivered bool
+		expiresAt   time.Time
+	}
+)
+
+// NewBroker returns a Broker with the default exchange and the amq.direct, amq.topic and amq.fanout exchanges.
+func NewBroker() *Broker {
+	b := &Broker{
+		exchanges: make(map[string]*exchange),
+		queues:    make(map[string]*queue),
+		conns:     make(map[*Connection]struct{}),
+	}
+
+	for name, kind := range map[string]string{
+		"":           amqp.ExchangeDirect,
+		"amq.direct": amqp.ExchangeDirect,
+		"amq.topic":  amqp.ExchangeTopic,
+		"amq.fanout": amqp.ExchangeFanout,
+	} {
+		b.exchanges[name] = &exchange{name: name, kind: kind}
+	}
+
+	return b
+}
+
+// Dial opens a connection to the broker, the url and the config are ignored.
+func (b *Broker) Dial(_ string, _ amqp.Config) (rabbitmq.AMQPConnection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dialErr != nil {
+		return nil, b.dialErr
+	}
+
+	conn := &Connection{broker: b, channels: make(map[*Channel]struct{})}
+	b.conns[conn] = struct{}{}
+
+	return conn, nil
+}
+
+// SetDialError makes Dial fail with err until it is called with nil.
+func (b *Broker) SetDialError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dialErr = err
+}
+
+// DropConnections closes all the connections with a connection-forced error, like a broker restart does.
+func (b *Broker) DropConnections() {
+	b.mu.Lock()
+	conns := make([]*Connection, 0, len(b.conns))
+	for conn := range b.conns {
+		conns = append(conns, conn)
+	}
+	b.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.shutdown(&amqp.Error{
+			Code:   amqp.ConnectionForced,
+			Reason: "CONNECTION_FORCED - broker forced connection closure with reason 'shutdown'",
+			Server: true,
+		})
+	}
+}
+
+// SetFlow sends channel.flow to all the channels, publishers pause while it is not active.
+func (b *Broker) SetFlow(active bool) {
+	for _, ch := range b.channels() {
+		ch.notifyFlow(active)
+	}
+}
+
+// Connections returns the number of open connections.
+func (b *Broker) Connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.conns)
+}
+
+// QueueLen returns the number of messages ready to be delivered from the queue.
+func (b *Broker) QueueLen(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		return 0
+	}
+	b.expire(q)
+
+	return len(q.messages)
+}
+
+// Unacked returns the number of messages delivered from the queue and not settled yet.
+func (b *Broker) Unacked(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for conn := range b.conns {
+		for ch := range conn.channels {
+			for _, u := range ch.unacked {
+				if u.queue.name == name {
+					n++
+				}
+			}
+		}
+	}
+
+	return n
+}
+
+// Consumers returns the number of consumers of the queue.
+func (b *Broker) Consumers(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		return 0
+	}
+
+	return len(q.consumers)
+}
+
+// DialerInitFunc opens a channel on a connection of Broker, see rabbitmq.WithInitFunc.
+func DialerInitFunc(conn rabbitmq.AMQPConnection) (rabbitmq.AMQPChannel, error) {
+	c, ok := conn.(*Connection)
+	if !ok {
+		return nil, fmt.Errorf("connection %T is not a fake connection", conn)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// ConsumerInitFunc opens a channel on a connection of Broker, see consumer.WithInitFunc.
+func ConsumerInitFunc(conn consumer.AMQPConnection) (consumer.AMQPChannel, error) {
+	c, ok := conn.(*Connection)
+	if !ok {
+		return nil, fmt.Errorf("connection %T is not a fake connection", conn)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// PublisherInitFunc opens a channel on a connection of Broker, see publisher.WithInitFunc.
+func PublisherInitFunc(conn publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+	c, ok := conn.(*Connection)
+	if !ok {
+		return nil, fmt.Errorf("connection %T is not a fake connection", conn)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+func (b *Broker) channels() []*Channel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var chs []*Channel
+	for conn := range b.conns {
+		for ch := range conn.channels {
+			chs = append(chs, ch)
+		}
+	}
+
+	return chs
+}
+
+// route returns the queues the key is routed to from the exchange. The lock must be held.
+func (b *Broker) route(ex *exchange, key string, visited map[string]bool) []*queue {
+	if visited[ex.name] {
+		return nil
+	}
+	visited[ex.name] = true
+
+	if ex.name == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*queue{q}
+		}
+		return nil
+	}
+
+	var queues []*queue
+	for _, bind := range ex.bindings {
+		if !matchKey(ex.kind, bind.key, key) {
+			continue
+		}
+
+		if !bind.toExchange {
+			if q, ok := b.queues[bind.destination]; ok && !containsQueue(queues, q) {
+				queues = append(queues, q)
+			}
+			continue
+		}
+
+		if dest, ok := b.exchanges[bind.destination]; ok {
+			for _, q := range b.route(dest, key, visited) {
+				if !containsQueue(queues, q) {
+					queues = append(queues, q)
+				}
+			}
+		}
+	}
+
+	return queues
+}
+
+// enqueue adds the message to the queue and delivers it. The lock must be held.
+func (b *Broker) enqueue(q *queue, m *message) {
+	if ttl, ok := queueTTL(q.args); ok {
+		m.expiresAt = time.Now().Add(ttl)
+		time.AfterFunc(ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if b.queues[q.name] == q {
+				b.expire(q)
+			}
+		})
+	}
+
+	q.messages = append(q.messages, m)
+	b.dispatch(q)
+}
+
+// requeue puts the message back at the head of the queue. The lock must be held.
+func (b *Broker) requeue(q *queue, m *message) {
+	m.redelivered = true
+	q.messages = append([]*message{m}, q.messages...)
+}
+
+// expire dead-letters the expired messages at the head of the queue. The lock must be held.
+func (b *Broker) expire(q *queue) {
+	now := time.Now()
+	for len(q.messages) > 0 && !q.messages[0].expiresAt.IsZero() && !now.Before(q.messages[0].expiresAt) {
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		b.deadLetter(q, m, "expired")
+	}
+}
+
+// deadLetter publishes the message to the dead-letter exchange of the queue, if any. The lock must be held.
+func (b *Broker) deadLetter(q *queue, m *message, reason string) {
+	name, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+
+	ex, ok := b.exchanges[name]
+	if !ok {
+		return
+	}
+
+	key := m.key
+	if k, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = k
+	}
+
+	publishing := m.publishing
+	headers := amqp.Table{}
+	for k, v := range publishing.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers["x-first-death-queue"]; !ok {
+		headers["x-first-death-queue"] = q.name
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-exchange"] = m.exchange
+	}
+	publishing.Headers = headers
+	// The per-message TTL is removed once a message is dead-lettered.
+	publishing.Expiration = ""
+
+	for _, dest := range b.route(ex, key, map[string]bool{}) {
+		b.enqueue(dest, &message{exchange: name, key: key, publishing: publishing})
+	}
+}
+
+// dispatch delivers the messages of the queue to its consumers with free capacity, round-robin.
+// The lock must be held.
+func (b *Broker) dispatch(q *queue) {
+	b.expire(q)
+
+	for len(q.messages) > 0 {
+		c := q.nextConsumer()
+		if c == nil {
+			return
+		}
+
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		c.deliver(q, m)
+
+		b.expire(q)
+	}
+}
+
+// deleteQueue removes the queue and its bindings and cancels its consumers. The lock must be held.
+// It returns the canceled consumers, their channels must be notified without the lock.
+func (b *Broker) deleteQueue(q *queue) []*queueConsumer {
+	delete(b.queues, q.name)
+
+	for _, ex := range b.exchanges {
+		bindings := ex.bindings[:0]
+		for _, bind := range ex.bindings {
+			if bind.toExchange || bind.destination != q.name {
+				bindings = append(bindings, bind)
+			}
+		}
+		ex.bindings = bindings
+	}
+
+	canceled := q.consumers
+	q.consumers = nil
+	for _, c := range canceled {
+		delete(c.ch.consumers, c.tag)
+		c.cancel()
+	}
+
+	return canceled
+}
+
+// removeConsumer detaches the consumer from its queue and deletes an auto-delete queue without consumers left.
+// The lock must be held.
+func (b *Broker) removeConsumer(c *queueConsumer) []*queueConsumer {
+	q := c.queue
+	for i, other := range q.consumers {
+		if other == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+
+	if q.autoDelete && q.hadConsumer && len(q.consumers) == 0 && b.queues[q.name] == q {
+		return b.deleteQueue(q)
+	}
+
+	return nil
+}
+
+func (b *Broker) id(prefix string) string {
+	b.nextID++
+	return fmt.Sprintf("%s%d", prefix, b.nextID)
+}
+
+// nextConsumer returns the next consumer with free capacity. The lock must be held.
+func (q *queue) nextConsumer() *queueConsumer {
+	for i := 0; i < len(q.consumers); i++ {
+		c := q.consumers[(q.next+i)%len(q.consumers)]
+		if c.ready() {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return c
+		}
+	}
+
+	return nil
+}
+
+func matchKey(kind, pattern, key string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return matchTopic(strings.Split(pattern, "."), strings.Split(key, "."))
+	default:
+		return pattern == key
+	}
+}
+
+// matchTopic matches the words of a routing key against a binding key,
+// * matches exactly one word and # matches zero or more words.
+func matchTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
+
+func queueTTL(args amqp.Table) (time.Duration, bool) {
+	var ms int64
+	switch v := args["x-message-ttl"].(type) {
+	case int:
+		ms = int64(v)
+	case int32:
+		ms = int64(v)
+	case int64:
+		ms = v
+	default:
+		return 0, false
+	}
+
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func containsQueue(queues []*queue, q *queue) bool {
+	for _, other := range queues {
+		if other == q {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedTags(unacked map[uint64]*unacked) []uint64 {
+	tags := make([]uint64, 0, len(unacked))
+	for tag := range unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	return tags
+}
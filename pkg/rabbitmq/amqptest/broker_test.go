@@ -0,0 +1,323 @@
+package amqptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+func newDialer(t *testing.T, b *Broker, opts ...rabbitmq.Option) *rabbitmq.Dialer {
+	t.Helper()
+
+	d, err := rabbitmq.NewDialer(append([]rabbitmq.Option{
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(DialerInitFunc),
+		rabbitmq.WithRetryPeriod(10 * time.Millisecond),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+
+	return d
+}
+
+func startConsumer(t *testing.T, d *rabbitmq.Dialer, queue string, h consumer.Handler) <-chan consumer.State {
+	t.Helper()
+
+	stateCh := make(chan consumer.State, 1)
+	c, err := d.Consumer(
+		consumer.WithQueue(queue),
+		consumer.WithHandler(h),
+		consumer.WithNotify(stateCh),
+		consumer.WithRetryPeriod(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return stateCh
+}
+
+func waitConsumerReady(t *testing.T, stateCh <-chan consumer.State) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case state := <-stateCh:
+			if state.Ready != nil {
+				return
+			}
+		case <-timeout:
+			t.Fatal("consumer is not ready")
+		}
+	}
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+
+	select {
+	case msg := <-deliveries:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("message was not received")
+		return amqp.Delivery{}
+	}
+}
+
+func forward(deliveries chan<- amqp.Delivery) consumer.Handler {
+	return consumer.HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		deliveries <- msg
+		return consumer.Ack()
+	})
+}
+
+func TestBroker_ConsumesWhatDialerPublishes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if _, err := rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := make(chan amqp.Delivery, 1)
+	waitConsumerReady(t, startConsumer(t, d, "orders", forward(deliveries)))
+
+	p, err := d.Publisher(publisher.WithConfirmation(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err = p.Publish(publisher.Message{
+		Key:        "orders",
+		Publishing: amqp.Publishing{MessageId: "1", Body: []byte("created")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := receive(t, deliveries); msg.MessageId != "1" || string(msg.Body) != "created" {
+		t.Fatalf("unexpected message %s %s", msg.MessageId, msg.Body)
+	}
+
+	// The ack is settled by the worker right after the handler returns.
+	time.Sleep(20 * time.Millisecond)
+	if n := b.Unacked("orders"); n != 0 {
+		t.Fatalf("%d messages are not acknowledged", n)
+	}
+}
+
+func TestBroker_ReconnectsAfterDropConnections(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if _, err := rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := make(chan amqp.Delivery, 1)
+	stateCh := startConsumer(t, d, "orders", forward(deliveries))
+	waitConsumerReady(t, stateCh)
+
+	p, err := d.Publisher(publisher.WithConfirmation(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	b.DropConnections()
+
+	// The consumer gets unready, then ready again on the next connection.
+	waitConsumerReady(t, stateCh)
+
+	if err = p.Publish(publisher.Message{Key: "orders", Publishing: amqp.Publishing{MessageId: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := receive(t, deliveries); msg.MessageId != "2" {
+		t.Fatalf("unexpected message %s", msg.MessageId)
+	}
+
+	if n := b.Consumers("orders"); n != 1 {
+		t.Fatalf("%d consumers, want 1", n)
+	}
+}
+
+func TestBroker_ReturnsUnroutableMandatoryMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if _, err := rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := d.Publisher(publisher.WithConfirmation(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	results, err := p.PublishBatch(ctx, []publisher.Message{
+		{Key: "orders", Mandatory: true},
+		{Key: "missing", Mandatory: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Err != nil {
+		t.Fatalf("routed message failed: %s", results[0].Err)
+	}
+
+	if !errors.Is(results[1].Err, publisher.ErrReturned) || results[1].Returned == nil || results[1].Returned.RoutingKey != "missing" {
+		t.Fatalf("unexpected result of the unroutable message %+v", results[1])
+	}
+
+	if n := b.QueueLen("orders"); n != 1 {
+		t.Fatalf("%d messages in queue, want 1", n)
+	}
+}
+
+func TestBroker_AnswersRPCCalls(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if _, err := rabbitmq.Queue(ctx, d, "rpc", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	replier, err := d.Publisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replier.Close()
+
+	waitConsumerReady(t, startConsumer(t, d, "rpc", consumer.HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		if err := replier.Publish(publisher.Message{
+			Key: msg.ReplyTo,
+			Publishing: amqp.Publishing{
+				CorrelationId: msg.CorrelationId,
+				Body:          append([]byte("re: "), msg.Body...),
+			},
+		}); err != nil {
+			return consumer.Requeue(err, 0)
+		}
+		return consumer.Ack()
+	})))
+
+	client, err := rabbitmq.NewRPCClient(d,
+		rabbitmq.WithRPCPublisherOptions(publisher.WithConfirmation(1)),
+		rabbitmq.WithRPCConsumerOptions(consumer.WithRetryPeriod(10*time.Millisecond)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply, err := client.Call(ctx, publisher.Message{Key: "rpc", Publishing: amqp.Publishing{Body: []byte("ping")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(reply.Body) != "re: ping" {
+		t.Fatalf("unexpected reply %s", reply.Body)
+	}
+}
+
+func TestBroker_DeclaresThroughDialerChannels(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if err := rabbitmq.DeclareRetryTopology(ctx, d, rabbitmq.RetryTopology{Queue: "orders", RetryDelay: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	topology := rabbitmq.Topology{
+		Exchanges: []rabbitmq.ExchangeSpec{{Name: "events", Kind: amqp.ExchangeTopic}},
+		Bindings:  []rabbitmq.BindingSpec{{Source: "events", Destination: "orders", Key: "orders.#"}},
+	}
+	if err := topology.Declare(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := d.Publisher(publisher.WithConfirmation(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err = p.Publish(publisher.Message{Exchange: "events", Key: "orders.created"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := b.QueueLen("orders"); n != 1 {
+		t.Fatalf("%d messages in queue, want 1", n)
+	}
+
+	// The fake connections are not streadway's, Dialer.Connection fails instead of panicking.
+	if _, err = d.Connection(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBroker_DeclaresDialerTopologiesOnEveryConnection(t *testing.T) {
+	b := NewBroker()
+	d := newDialer(t, b, rabbitmq.WithTopology(rabbitmq.Topology{
+		Queues: []rabbitmq.QueueSpec{{Name: "orders", Durable: true}},
+	}))
+
+	stateCh := startConsumer(t, d, "orders", forward(make(chan amqp.Delivery, 1)))
+	waitConsumerReady(t, stateCh)
+
+	b.DropConnections()
+	waitConsumerReady(t, stateCh)
+
+	if n := b.Consumers("orders"); n != 1 {
+		t.Fatalf("%d consumers, want 1", n)
+	}
+}
+
+func TestBroker_DialerWithoutInitFuncCantDeclareTopologies(t *testing.T) {
+	b := NewBroker()
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithRetryPeriod(10*time.Millisecond),
+		rabbitmq.WithTopology(rabbitmq.Topology{Queues: []rabbitmq.QueueSpec{{Name: "orders"}}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	select {
+	case <-d.NotifyClosed():
+	case <-time.After(time.Second):
+		t.Fatal("dialer keeps retrying")
+	}
+}
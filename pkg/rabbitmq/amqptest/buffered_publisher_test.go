@@ -0,0 +1,81 @@
+package amqptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+func TestBufferedPublisher_PublishesSpilledMessagesAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+	dir := t.TempDir()
+
+	if _, err := rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The publisher never gets a channel, the messages stay buffered.
+	unready, err := d.Publisher(publisher.WithInitFunc(func(publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+		return nil, errors.New("channel unavailable")
+	}), publisher.WithRestartSleep(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unready.Close()
+
+	buffered, err := publisher.NewBufferedPublisher(unready, publisher.WithBufferSize(1), publisher.WithSpillDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err = buffered.Publish(publisher.Message{Key: "orders", Publishing: amqp.Publishing{MessageId: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := buffered.Len(); n != 3 {
+		t.Fatalf("%d messages buffered, want 3", n)
+	}
+
+	// The message in memory is written to disk before the spilled ones.
+	buffered.Close()
+
+	p, err := d.Publisher(publisher.WithConfirmation(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	buffered, err = publisher.NewBufferedPublisher(p, publisher.WithSpillDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffered.Close()
+
+	deliveries := make(chan amqp.Delivery, 3)
+	waitConsumerReady(t, startConsumer(t, d, "orders", forward(deliveries)))
+
+	for _, id := range []string{"1", "2", "3"} {
+		if msg := receive(t, deliveries); msg.MessageId != id {
+			t.Fatalf("received message %s, want %s", msg.MessageId, id)
+		}
+	}
+
+	timeout := time.After(time.Second)
+	for buffered.Len() != 0 {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("%d messages still buffered", buffered.Len())
+		}
+	}
+}
@@ -0,0 +1,652 @@
+package amqptest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+type (
+	// Channel is a fake channel of a Connection. It implements consumer.AMQPChannel,
+	// publisher.AMQPChannel and amqp.Acknowledger for the deliveries it consumes.
+	// Like RabbitMQ, the broker closes the channel with an *amqp.Error on a protocol error,
+	// for example a publishing to an unknown exchange or an ack of an unknown delivery tag.
+	Channel struct {
+		broker *Broker
+		conn   *Connection
+
+		// The fields below are guarded by the broker lock.
+		closed         bool
+		confirm        bool
+		publishSeq     uint64
+		deliveryTag    uint64
+		prefetch       int
+		prefetchGlobal int
+		unacked        map[uint64]*unacked
+		consumers      map[string]*queueConsumer
+
+		// publishMu keeps the confirmations in the publishing order.
+		publishMu sync.Mutex
+
+		notifyMu     sync.Mutex
+		notifyClosed bool
+		closes       []chan *amqp.Error
+		flows        []chan bool
+		confirms     []chan amqp.Confirmation
+		returns      []chan amqp.Return
+		cancels      []chan string
+	}
+
+	unacked struct {
+		queue    *queue
+		message  *message
+		consumer *queueConsumer
+	}
+)
+
+// Publish routes the publishing to the queues bound to the exchange.
+func (ch *Channel) Publish(exchangeName, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.publishMu.Lock()
+	defer ch.publishMu.Unlock()
+
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+
+	ex, ok := b.exchanges[exchangeName]
+	if !ok || ex.internal || immediate {
+		b.mu.Unlock()
+
+		// RabbitMQ closes the channel asynchronously, the publishing itself doesn't fail.
+		switch {
+		case !ok:
+			ch.fail(amqp.NotFound, "NOT_FOUND - no exchange '%s'", exchangeName)
+		case ex.internal:
+			ch.fail(amqp.AccessRefused, "ACCESS_REFUSED - cannot publish to internal exchange '%s'", exchangeName)
+		default:
+			ch.fail(amqp.NotImplemented, "NOT_IMPLEMENTED - immediate=true")
+		}
+		return nil
+	}
+
+	var seq uint64
+	if ch.confirm {
+		ch.publishSeq++
+		seq = ch.publishSeq
+	}
+
+	queues := b.route(ex, key, map[string]bool{})
+	for _, q := range queues {
+		b.enqueue(q, &message{exchange: exchangeName, key: key, publishing: msg})
+	}
+	b.mu.Unlock()
+
+	if mandatory && len(queues) == 0 {
+		ch.notifyReturn(returnOf(exchangeName, key, msg))
+	}
+
+	if seq > 0 {
+		ch.notifyConfirm(amqp.Confirmation{DeliveryTag: seq, Ack: true})
+	}
+
+	return nil
+}
+
+// Confirm puts the channel into confirm mode.
+func (ch *Channel) Confirm(_ bool) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	ch.confirm = true
+	return nil
+}
+
+// Qos sets the prefetch count of the next consumers, or of the whole channel when global is true.
+// The prefetch size is ignored.
+func (ch *Channel) Qos(prefetchCount, _ int, global bool) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if global {
+		ch.prefetchGlobal = prefetchCount
+	} else {
+		ch.prefetch = prefetchCount
+	}
+
+	return nil
+}
+
+// ExchangeDeclare declares a direct, topic or fanout exchange.
+func (ch *Channel) ExchangeDeclare(name, kind string, _, _, internal, _ bool, _ amqp.Table) error {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+
+	if ex, ok := b.exchanges[name]; ok {
+		b.mu.Unlock()
+		if ex.kind != kind {
+			return ch.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s'", name)
+		}
+		return nil
+	}
+
+	b.mu.Unlock()
+
+	switch {
+	case name == "" || strings.HasPrefix(name, "amq."):
+		return ch.fail(amqp.AccessRefused, "ACCESS_REFUSED - exchange name '%s' contains reserved prefix 'amq.'", name)
+	case kind != amqp.ExchangeDirect && kind != amqp.ExchangeTopic && kind != amqp.ExchangeFanout:
+		return ch.fail(amqp.CommandInvalid, "COMMAND_INVALID - unknown exchange type '%s'", kind)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.exchanges[name]; !ok {
+		b.exchanges[name] = &exchange{name: name, kind: kind, internal: internal}
+	}
+
+	return nil
+}
+
+// ExchangeBind binds the destination exchange to the source exchange.
+func (ch *Channel) ExchangeBind(destination, key, source string, _ bool, _ amqp.Table) error {
+	return ch.bind(destination, true, key, source)
+}
+
+// QueueDeclare declares a queue, a name is generated when it is empty.
+func (ch *Channel) QueueDeclare(name string, durable, autoDelete, exclusive, _ bool, args amqp.Table) (amqp.Queue, error) {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+
+	if name == "" {
+		name = b.id("amq.gen-")
+	}
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = &queue{
+			name:       name,
+			durable:    durable,
+			autoDelete: autoDelete,
+			exclusive:  exclusive,
+			args:       args,
+		}
+		if exclusive {
+			q.owner = ch.conn
+		}
+		b.queues[name] = q
+	}
+
+	var (
+		code   int
+		reason string
+	)
+	switch {
+	case q.exclusive && q.owner != ch.conn:
+		code, reason = amqp.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'"
+	case q.durable != durable || q.autoDelete != autoDelete || q.exclusive != exclusive:
+		code, reason = amqp.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arg for queue '%s'"
+	}
+
+	result := amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}
+	b.mu.Unlock()
+
+	if code != 0 {
+		return amqp.Queue{}, ch.fail(code, reason, name)
+	}
+
+	return result, nil
+}
+
+// QueueBind binds the queue to the exchange.
+func (ch *Channel) QueueBind(name, key, exchangeName string, _ bool, _ amqp.Table) error {
+	return ch.bind(name, false, key, exchangeName)
+}
+
+// QueueDelete deletes the queue and cancels its consumers. It returns the number of messages deleted.
+func (ch *Channel) QueueDelete(name string, _, _, _ bool) (int, error) {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return 0, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[name]
+	if !ok {
+		b.mu.Unlock()
+		return 0, nil
+	}
+
+	n := len(q.messages)
+	canceled := b.deleteQueue(q)
+	b.mu.Unlock()
+
+	notifyCanceled(canceled)
+
+	return n, nil
+}
+
+// Consume starts delivering the messages of the queue. A consumer tag is generated when it is empty.
+// The no-local flag and the arguments are ignored.
+func (ch *Channel) Consume(
+	queueName, tag string,
+	autoAck, exclusive, _, _ bool,
+	_ amqp.Table,
+) (<-chan amqp.Delivery, error) {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return nil, amqp.ErrClosed
+	}
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		b.mu.Unlock()
+		return nil, ch.fail(amqp.NotFound, "NOT_FOUND - no queue '%s'", queueName)
+	}
+
+	if tag == "" {
+		tag = b.id("ctag-")
+	}
+
+	if _, ok = ch.consumers[tag]; ok {
+		b.mu.Unlock()
+		return nil, ch.fail(amqp.NotAllowed, "NOT_ALLOWED - attempt to reuse consumer tag '%s'", tag)
+	}
+
+	for _, other := range q.consumers {
+		if exclusive || other.exclusive {
+			b.mu.Unlock()
+			return nil, ch.fail(amqp.AccessRefused, "ACCESS_REFUSED - queue '%s' in exclusive use", queueName)
+		}
+	}
+
+	c := newConsumer(ch, q, tag, autoAck, exclusive)
+	ch.consumers[tag] = c
+	q.consumers = append(q.consumers, c)
+	q.hadConsumer = true
+
+	go c.run()
+
+	b.dispatch(q)
+	b.mu.Unlock()
+
+	return c.out, nil
+}
+
+// Cancel stops a consumer. The deliveries already sent are still received before its chan is closed.
+func (ch *Channel) Cancel(tag string, _ bool) error {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+
+	c, ok := ch.consumers[tag]
+	if !ok {
+		b.mu.Unlock()
+		return nil
+	}
+
+	delete(ch.consumers, tag)
+	c.cancel()
+	canceled := b.removeConsumer(c)
+	b.mu.Unlock()
+
+	notifyCanceled(canceled)
+
+	return nil
+}
+
+// Ack acknowledges a delivery, or all the deliveries up to tag when multiple is true.
+func (ch *Channel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, func(u *unacked) {})
+}
+
+// Nack rejects a delivery, or all the deliveries up to tag when multiple is true.
+// The deliveries not requeued are dead-lettered.
+func (ch *Channel) Nack(tag uint64, multiple, requeue bool) error {
+	return ch.settle(tag, multiple, func(u *unacked) {
+		if requeue {
+			ch.broker.requeue(u.queue, u.message)
+		} else {
+			ch.broker.deadLetter(u.queue, u.message, "rejected")
+		}
+	})
+}
+
+// Reject rejects a delivery.
+func (ch *Channel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+// NotifyClose registers a listener for the close of the channel, it receives the error of a broker close.
+func (ch *Channel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	if ch.notifyClosed {
+		close(receiver)
+	} else {
+		ch.closes = append(ch.closes, receiver)
+	}
+
+	return receiver
+}
+
+// NotifyFlow registers a listener for the flow control of Broker.SetFlow.
+func (ch *Channel) NotifyFlow(c chan bool) chan bool {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	if ch.notifyClosed {
+		close(c)
+	} else {
+		ch.flows = append(ch.flows, c)
+	}
+
+	return c
+}
+
+// NotifyPublish registers a listener for the publisher confirms.
+func (ch *Channel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	if ch.notifyClosed {
+		close(confirm)
+	} else {
+		ch.confirms = append(ch.confirms, confirm)
+	}
+
+	return confirm
+}
+
+// NotifyReturn registers a listener for the unroutable mandatory publishings.
+func (ch *Channel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	if ch.notifyClosed {
+		close(c)
+	} else {
+		ch.returns = append(ch.returns, c)
+	}
+
+	return c
+}
+
+// NotifyCancel registers a listener for the consumers canceled by the broker, when their queue is deleted.
+func (ch *Channel) NotifyCancel(c chan string) chan string {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	if ch.notifyClosed {
+		close(c)
+	} else {
+		ch.cancels = append(ch.cancels, c)
+	}
+
+	return c
+}
+
+// Close closes the channel, its unacked deliveries are requeued.
+func (ch *Channel) Close() error {
+	ch.broker.mu.Lock()
+	closed := ch.closed
+	ch.broker.mu.Unlock()
+
+	if closed {
+		return amqp.ErrClosed
+	}
+
+	ch.shutdown(nil)
+	return nil
+}
+
+func (ch *Channel) bind(destination string, toExchange bool, key, source string) error {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+
+	ex, ok := b.exchanges[source]
+	_, destOk := b.queues[destination]
+	if toExchange {
+		_, destOk = b.exchanges[destination]
+	}
+	b.mu.Unlock()
+
+	switch {
+	case !ok:
+		return ch.fail(amqp.NotFound, "NOT_FOUND - no exchange '%s'", source)
+	case !destOk:
+		return ch.fail(amqp.NotFound, "NOT_FOUND - no destination '%s'", destination)
+	case source == "":
+		return ch.fail(amqp.AccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bind := binding{destination: destination, toExchange: toExchange, key: key}
+	for _, other := range ex.bindings {
+		if other == bind {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, bind)
+
+	return nil
+}
+
+func (ch *Channel) settle(tag uint64, multiple bool, settle func(u *unacked)) error {
+	b := ch.broker
+	b.mu.Lock()
+
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+
+	var tags []uint64
+	if multiple {
+		for _, t := range sortedTags(ch.unacked) {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+	} else if _, ok := ch.unacked[tag]; ok {
+		tags = []uint64{tag}
+	}
+
+	if len(tags) == 0 && !(multiple && tag == 0) {
+		b.mu.Unlock()
+		return ch.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - unknown delivery tag %d", tag)
+	}
+
+	// The requeued deliveries are put back at the head of their queue, the last one first to keep the order.
+	for i := len(tags) - 1; i >= 0; i-- {
+		u := ch.unacked[tags[i]]
+		delete(ch.unacked, tags[i])
+		u.consumer.unacked--
+		settle(u)
+	}
+
+	ch.dispatchConsumers()
+	b.mu.Unlock()
+
+	return nil
+}
+
+// dispatchConsumers delivers to the consumers of the channel once capacity is freed. The lock must be held.
+func (ch *Channel) dispatchConsumers() {
+	for _, c := range ch.consumers {
+		ch.broker.dispatch(c.queue)
+	}
+}
+
+// fail closes the channel with a server error and returns it. The lock must not be held.
+func (ch *Channel) fail(code int, format string, args ...interface{}) error {
+	err := &amqp.Error{Code: code, Reason: fmt.Sprintf(format, args...), Server: true}
+	ch.shutdown(err)
+
+	return err
+}
+
+func (ch *Channel) shutdown(err *amqp.Error) {
+	b := ch.broker
+	b.mu.Lock()
+	if ch.closed {
+		b.mu.Unlock()
+		return
+	}
+	ch.closed = true
+	delete(ch.conn.channels, ch)
+
+	var canceled []*queueConsumer
+	for tag, c := range ch.consumers {
+		delete(ch.consumers, tag)
+		c.stop()
+		canceled = append(canceled, b.removeConsumer(c)...)
+	}
+
+	queues := make(map[*queue]struct{})
+	tags := sortedTags(ch.unacked)
+	for i := len(tags) - 1; i >= 0; i-- {
+		u := ch.unacked[tags[i]]
+		delete(ch.unacked, tags[i])
+		b.requeue(u.queue, u.message)
+		queues[u.queue] = struct{}{}
+	}
+	for q := range queues {
+		if b.queues[q.name] == q {
+			b.dispatch(q)
+		}
+	}
+	b.mu.Unlock()
+
+	notifyCanceled(canceled)
+
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	ch.notifyClosed = true
+	for _, c := range ch.closes {
+		if err != nil {
+			c <- err
+		}
+		close(c)
+	}
+	for _, c := range ch.flows {
+		close(c)
+	}
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	for _, c := range ch.returns {
+		close(c)
+	}
+	for _, c := range ch.cancels {
+		close(c)
+	}
+	ch.closes, ch.flows, ch.confirms, ch.returns, ch.cancels = nil, nil, nil, nil, nil
+}
+
+func (ch *Channel) notifyFlow(active bool) {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	for _, c := range ch.flows {
+		c <- active
+	}
+}
+
+func (ch *Channel) notifyConfirm(confirmation amqp.Confirmation) {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	for _, c := range ch.confirms {
+		c <- confirmation
+	}
+}
+
+func (ch *Channel) notifyReturn(r amqp.Return) {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	for _, c := range ch.returns {
+		c <- r
+	}
+}
+
+func (ch *Channel) notifyCancel(tag string) {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+
+	for _, c := range ch.cancels {
+		c <- tag
+	}
+}
+
+func notifyCanceled(consumers []*queueConsumer) {
+	for _, c := range consumers {
+		c.ch.notifyCancel(c.tag)
+	}
+}
+
+func returnOf(exchangeName, key string, msg amqp.Publishing) amqp.Return {
+	return amqp.Return{
+		ReplyCode:       amqp.NoRoute,
+		ReplyText:       "NO_ROUTE",
+		Exchange:        exchangeName,
+		RoutingKey:      key,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		Headers:         msg.Headers,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
@@ -0,0 +1,117 @@
+package amqptest
+
+import (
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// Connection is a fake connection to Broker.
+type Connection struct {
+	broker *Broker
+
+	// channels and closed are guarded by the broker lock.
+	channels map[*Channel]struct{}
+	closed   bool
+
+	notifyMu     sync.Mutex
+	notifyClosed bool
+	closes       []chan *amqp.Error
+}
+
+// Channel opens a channel.
+func (c *Connection) Channel() (*Channel, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	ch := &Channel{
+		broker:    c.broker,
+		conn:      c,
+		unacked:   make(map[uint64]*unacked),
+		consumers: make(map[string]*queueConsumer),
+	}
+	c.channels[ch] = struct{}{}
+
+	return ch, nil
+}
+
+// NotifyClose registers a listener for the close of the connection, it receives the error of a broker close.
+func (c *Connection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+
+	if c.notifyClosed {
+		close(receiver)
+	} else {
+		c.closes = append(c.closes, receiver)
+	}
+
+	return receiver
+}
+
+// Close closes the connection and its channels.
+func (c *Connection) Close() error {
+	c.broker.mu.Lock()
+	closed := c.closed
+	c.broker.mu.Unlock()
+
+	if closed {
+		return amqp.ErrClosed
+	}
+
+	c.shutdown(nil)
+	return nil
+}
+
+// IsClosed returns true once the connection is closed.
+func (c *Connection) IsClosed() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	return c.closed
+}
+
+func (c *Connection) shutdown(err *amqp.Error) {
+	c.broker.mu.Lock()
+	if c.closed {
+		c.broker.mu.Unlock()
+		return
+	}
+	c.closed = true
+	delete(c.broker.conns, c)
+
+	channels := make([]*Channel, 0, len(c.channels))
+	for ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	c.broker.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(err)
+	}
+
+	// The exclusive queues are deleted once their messages are requeued by the channels.
+	c.broker.mu.Lock()
+	for _, q := range c.broker.queues {
+		if q.exclusive && q.owner == c {
+			c.broker.deleteQueue(q)
+		}
+	}
+	c.broker.mu.Unlock()
+
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+
+	c.notifyClosed = true
+	for _, receiver := range c.closes {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+	c.closes = nil
+}
@@ -0,0 +1,142 @@
+package amqptest
+
+import (
+	"github.com/streadway/amqp"
+)
+
+// queueConsumer buffers its deliveries so the broker never blocks on a slow client.
+// Its fields are guarded by the broker lock.
+type queueConsumer struct {
+	ch        *Channel
+	queue     *queue
+	tag       string
+	autoAck   bool
+	exclusive bool
+	prefetch  int
+	unacked   int
+
+	buf      []amqp.Delivery
+	canceled bool
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	out    chan amqp.Delivery
+}
+
+func newConsumer(ch *Channel, q *queue, tag string, autoAck, exclusive bool) *queueConsumer {
+	return &queueConsumer{
+		ch:        ch,
+		queue:     q,
+		tag:       tag,
+		autoAck:   autoAck,
+		exclusive: exclusive,
+		prefetch:  ch.prefetch,
+		wakeCh:    make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		out:       make(chan amqp.Delivery),
+	}
+}
+
+// ready returns true when the QoS of the consumer and its channel allows one more delivery.
+func (c *queueConsumer) ready() bool {
+	if c.canceled || c.ch.closed {
+		return false
+	}
+
+	if c.autoAck {
+		return true
+	}
+
+	return (c.prefetch == 0 || c.unacked < c.prefetch) &&
+		(c.ch.prefetchGlobal == 0 || len(c.ch.unacked) < c.ch.prefetchGlobal)
+}
+
+func (c *queueConsumer) deliver(q *queue, m *message) {
+	c.ch.deliveryTag++
+	tag := c.ch.deliveryTag
+
+	if !c.autoAck {
+		c.ch.unacked[tag] = &unacked{queue: q, message: m, consumer: c}
+		c.unacked++
+	}
+
+	p := m.publishing
+	c.buf = append(c.buf, amqp.Delivery{
+		Acknowledger:    c.ch,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     c.tag,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.key,
+		Body:            p.Body,
+	})
+
+	c.wake()
+}
+
+// cancel closes the deliveries chan once the buffered deliveries are received.
+func (c *queueConsumer) cancel() {
+	c.canceled = true
+	c.wake()
+}
+
+// stop closes the deliveries chan right away, the buffered deliveries are requeued by the channel.
+func (c *queueConsumer) stop() {
+	c.canceled = true
+	c.buf = nil
+	close(c.stopCh)
+}
+
+func (c *queueConsumer) wake() {
+	select {
+	case c.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (c *queueConsumer) run() {
+	defer close(c.out)
+
+	b := c.ch.broker
+	for {
+		b.mu.Lock()
+		if len(c.buf) == 0 {
+			canceled := c.canceled
+			b.mu.Unlock()
+
+			if canceled {
+				return
+			}
+
+			select {
+			case <-c.wakeCh:
+				continue
+			case <-c.stopCh:
+				return
+			}
+		}
+
+		d := c.buf[0]
+		c.buf = c.buf[1:]
+		b.mu.Unlock()
+
+		select {
+		case c.out <- d:
+		case <-c.stopCh:
+			return
+		}
+	}
+}
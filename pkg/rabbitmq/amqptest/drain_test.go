@@ -0,0 +1,148 @@
+package amqptest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+// blockingHandler reports the deliveries on started and acks them once release is closed,
+// it requeues them when its context is canceled first.
+func blockingHandler(started chan<- string, release <-chan struct{}) consumer.Handler {
+	return consumer.HandlerFunc(func(ctx context.Context, msg amqp.Delivery) interface{} {
+		started <- msg.MessageId
+
+		select {
+		case <-release:
+			return consumer.Ack()
+		case <-ctx.Done():
+			return consumer.Requeue(ctx.Err(), 0)
+		}
+	})
+}
+
+// startDraining publishes 2 messages on the orders queue and returns a draining consumer once both are in flight.
+func startDraining(t *testing.T, b *Broker, timeout time.Duration, h consumer.Handler, started <-chan string) (*consumer.Consumer, *publisher.Publisher) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	d := newDialer(t, b)
+	if _, err := rabbitmq.Queue(ctx, d, "orders", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stateCh := make(chan consumer.State, 1)
+	c, err := d.Consumer(
+		consumer.WithQueue("orders"),
+		consumer.WithHandler(h),
+		consumer.WithWorker(consumer.NewParallelWorker(2)),
+		consumer.WithQos(2, false),
+		consumer.WithDrain(timeout),
+		consumer.WithNotify(stateCh),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	waitConsumerReady(t, stateCh)
+
+	p, err := d.Publisher(publisher.WithConfirmation(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	for _, id := range []string{"1", "2"} {
+		if err = p.Publish(publisher.Message{Key: "orders", Publishing: amqp.Publishing{MessageId: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-ctx.Done():
+			t.Fatal("messages are not in flight")
+		}
+	}
+
+	return c, p
+}
+
+func TestConsumer_DrainLetsInFlightDeliveriesFinish(t *testing.T) {
+	b := NewBroker()
+
+	started, release := make(chan string, 2), make(chan struct{})
+	c, p := startDraining(t, b, time.Second, blockingHandler(started, release), started)
+
+	c.Close()
+
+	// The consumption is canceled first, new messages stay in the queue.
+	timeout := time.After(time.Second)
+	for b.Consumers("orders") != 0 {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("the consumption was not canceled")
+		}
+	}
+	if err := p.Publish(publisher.Message{Key: "orders", Publishing: amqp.Publishing{MessageId: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-c.NotifyClosed():
+		t.Fatal("the consumer closed before the in-flight deliveries finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-c.NotifyClosed():
+	case <-time.After(time.Second):
+		t.Fatal("the consumer did not close once drained")
+	}
+
+	// Both in-flight deliveries were acked, the handler contexts were not canceled by Close.
+	if n := b.Unacked("orders"); n != 0 {
+		t.Fatalf("%d messages are not acknowledged", n)
+	}
+	if n := b.QueueLen("orders"); n != 1 {
+		t.Fatalf("%d messages in the queue, want 1", n)
+	}
+}
+
+func TestConsumer_DrainTimeoutRequeuesUnfinishedDeliveries(t *testing.T) {
+	b := NewBroker()
+
+	started, release := make(chan string, 2), make(chan struct{})
+	c, _ := startDraining(t, b, 50*time.Millisecond, blockingHandler(started, release), started)
+
+	start := time.Now()
+	c.Close()
+
+	select {
+	case <-c.NotifyClosed():
+	case <-time.After(time.Second):
+		t.Fatal("the consumer did not close after the drain timeout")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("closed after %s, before the drain timeout", elapsed)
+	}
+
+	// The handler contexts are canceled after the timeout, the deliveries are back in the queue.
+	if n := b.Unacked("orders"); n != 0 {
+		t.Fatalf("%d messages are still unacked", n)
+	}
+	if n := b.QueueLen("orders"); n != 2 {
+		t.Fatalf("%d messages in the queue, want 2", n)
+	}
+}
@@ -0,0 +1,173 @@
+package amqptest
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+type (
+	// pooledChannels records the channel of every publisher of a pool and counts the messages it publishes.
+	pooledChannels struct {
+		mu        sync.Mutex
+		channels  []*Channel
+		published []int32
+		failing   []bool
+	}
+
+	countingChannel struct {
+		*Channel
+		published *int32
+	}
+)
+
+func (c countingChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	atomic.AddInt32(c.published, 1)
+	return c.Channel.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+func newPool(t *testing.T, b *Broker, size int) (*publisher.Pool, *pooledChannels) {
+	t.Helper()
+
+	d := newDialer(t, b)
+	pooled := &pooledChannels{
+		channels:  make([]*Channel, size),
+		published: make([]int32, size),
+		failing:   make([]bool, size),
+	}
+
+	publishers := make([]*publisher.Publisher, 0, size)
+	for i := 0; i < size; i++ {
+		p, err := d.Publisher(publisher.WithInitFunc(pooled.initFunc(i)), publisher.WithRestartSleep(10*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		publishers = append(publishers, p)
+	}
+
+	pool, err := publisher.NewPool(publishers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool, pooled
+}
+
+func (p *pooledChannels) initFunc(i int) func(conn publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+	return func(conn publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.failing[i] {
+			return nil, fmt.Errorf("channel %d fails", i)
+		}
+
+		ch, err := PublisherInitFunc(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		p.channels[i] = ch.(*Channel)
+		return countingChannel{Channel: p.channels[i], published: &p.published[i]}, nil
+	}
+}
+
+func (p *pooledChannels) channel(i int) *Channel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.channels[i]
+}
+
+func (p *pooledChannels) fail(i int) {
+	p.mu.Lock()
+	p.failing[i] = true
+	p.mu.Unlock()
+
+	p.channel(i).fail(amqp.ChannelError, "channel %d closed", i)
+}
+
+func (p *pooledChannels) count(i int) int32 {
+	return atomic.LoadInt32(&p.published[i])
+}
+
+func waitPoolReady(t *testing.T, pool *publisher.Pool, n int) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for pool.Ready() != n {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("%d publishers ready, want %d", pool.Ready(), n)
+		}
+	}
+}
+
+func publishAll(t *testing.T, pool *publisher.Pool, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if err := pool.Publish(publisher.Message{Key: "orders", ErrOnUnready: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPool_RoundRobinsOverReadyPublishers(t *testing.T) {
+	pool, pooled := newPool(t, NewBroker(), 3)
+	waitPoolReady(t, pool, 3)
+
+	publishAll(t, pool, 6)
+
+	for i := 0; i < 3; i++ {
+		if n := pooled.count(i); n != 2 {
+			t.Fatalf("publisher %d published %d messages, want 2", i, n)
+		}
+	}
+}
+
+func TestPool_SkipsPausedPublishers(t *testing.T) {
+	pool, pooled := newPool(t, NewBroker(), 3)
+	waitPoolReady(t, pool, 3)
+
+	pooled.channel(0).notifyFlow(false)
+	waitPoolReady(t, pool, 2)
+
+	publishAll(t, pool, 4)
+
+	if n := pooled.count(0); n != 0 {
+		t.Fatalf("paused publisher published %d messages", n)
+	}
+	for i := 1; i < 3; i++ {
+		if n := pooled.count(i); n != 2 {
+			t.Fatalf("publisher %d published %d messages, want 2", i, n)
+		}
+	}
+
+	pooled.channel(0).notifyFlow(true)
+	waitPoolReady(t, pool, 3)
+}
+
+func TestPool_SkipsUnreadyPublishers(t *testing.T) {
+	pool, pooled := newPool(t, NewBroker(), 3)
+	waitPoolReady(t, pool, 3)
+
+	// The channel is closed by the broker and can't be opened again.
+	pooled.fail(1)
+	waitPoolReady(t, pool, 2)
+
+	publishAll(t, pool, 4)
+
+	if n := pooled.count(1); n != 0 {
+		t.Fatalf("unready publisher published %d messages", n)
+	}
+	if n := pooled.count(0) + pooled.count(2); n != 4 {
+		t.Fatalf("ready publishers published %d messages, want 4", n)
+	}
+}
@@ -0,0 +1,95 @@
+package amqptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+func TestRPCClient_FailsInFlightCallsWhenTheReplyQueueIsLost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := NewBroker()
+	d := newDialer(t, b)
+
+	if _, err := rabbitmq.Queue(ctx, d, "rpc", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	replier, err := d.Publisher(publisher.WithRestartSleep(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replier.Close()
+
+	// Requests are only answered once the connection was dropped.
+	received := make(chan struct{}, 1)
+	answer := make(chan struct{})
+	waitConsumerReady(t, startConsumer(t, d, "rpc", consumer.HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		select {
+		case <-answer:
+		default:
+			received <- struct{}{}
+			return consumer.Ack()
+		}
+
+		if err := replier.Publish(publisher.Message{
+			Key:        msg.ReplyTo,
+			Publishing: amqp.Publishing{CorrelationId: msg.CorrelationId, Body: msg.Body},
+		}); err != nil {
+			return consumer.Requeue(err, 0)
+		}
+		return consumer.Ack()
+	})))
+
+	// Without confirmations the request is published once the broker routed it, before the connection drops.
+	client, err := rabbitmq.NewRPCClient(d,
+		rabbitmq.WithRPCPublisherOptions(publisher.WithRestartSleep(10*time.Millisecond)),
+		rabbitmq.WithRPCConsumerOptions(consumer.WithRetryPeriod(10*time.Millisecond)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Call(ctx, publisher.Message{Key: "rpc", Publishing: amqp.Publishing{Body: []byte("lost")}})
+		errCh <- err
+	}()
+
+	select {
+	case <-received:
+	case <-ctx.Done():
+		t.Fatal("request was not received")
+	}
+
+	// The exclusive reply queue is deleted with the connection.
+	b.DropConnections()
+
+	select {
+	case err = <-errCh:
+		if !errors.Is(err, rabbitmq.ErrReplyQueueLost) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("in-flight call did not fail")
+	}
+
+	// The next calls use the reply queue declared on the new connection.
+	close(answer)
+	reply, err := client.Call(ctx, publisher.Message{Key: "rpc", Publishing: amqp.Publishing{Body: []byte("ping")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Body) != "ping" {
+		t.Fatalf("unexpected reply %s", reply.Body)
+	}
+}
@@ -0,0 +1,202 @@
+package codec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/json"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+// JSONContentType is the content type of the default marshaller.
+const JSONContentType = "application/json"
+
+var (
+	// ErrUnknownType is returned when the Type of a delivery is not registered.
+	ErrUnknownType = errors.New("codec: unknown message type")
+	// ErrUnknownContentType is returned when no marshaller is registered for the ContentType of a delivery.
+	ErrUnknownContentType = errors.New("codec: unknown content type")
+)
+
+type (
+	// Option could be used to configure Codec
+	Option func(c *Codec)
+
+	// HandlerFunc handles a delivery with its decoded body, which is a pointer to the registered type.
+	// It returns a handler result like consumer.Handler.
+	HandlerFunc func(ctx context.Context, msg amqp.Delivery, v interface{}) interface{}
+
+	// Codec encodes Go values into publishings and decodes deliveries into registered Go types.
+	// The ContentType property selects the marshaller and the Type property selects the Go type.
+	Codec struct {
+		marshallers        map[string]interfaces.Marshaller
+		defaultContentType string
+		types              map[string]reflect.Type
+		names              map[reflect.Type]string
+	}
+)
+
+// New returns Codec or a configuration error.
+// Without WithMarshaller, values are encoded in JSON.
+func New(opts ...Option) (*Codec, error) {
+	c := &Codec{
+		marshallers: make(map[string]interfaces.Marshaller),
+		types:       make(map[string]reflect.Type),
+		names:       make(map[reflect.Type]string),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if len(c.marshallers) == 0 {
+		c.marshallers[JSONContentType] = json.New()
+		c.defaultContentType = JSONContentType
+	}
+
+	if _, ok := c.marshallers[c.defaultContentType]; !ok {
+		return nil, fmt.Errorf("no marshaller registered for the default content type %s", c.defaultContentType)
+	}
+
+	for name, t := range c.types {
+		if name == "" {
+			return nil, fmt.Errorf("type name must be not empty")
+		}
+		if t == nil {
+			return nil, fmt.Errorf("type %s must be not nil", name)
+		}
+	}
+
+	return c, nil
+}
+
+// WithMarshaller configure the marshaller of a content type, for example a protobuf one for application/x-protobuf.
+// The first registered content type is used to encode by default.
+func WithMarshaller(contentType string, m interfaces.Marshaller) Option {
+	return func(c *Codec) {
+		if c.defaultContentType == "" {
+			c.defaultContentType = contentType
+		}
+		c.marshallers[contentType] = m
+	}
+}
+
+// WithDefaultContentType configure the content type used to encode.
+func WithDefaultContentType(contentType string) Option {
+	return func(c *Codec) {
+		c.defaultContentType = contentType
+	}
+}
+
+// WithType registers the type of the prototype under the name used in the Type property. Pointers are dereferenced.
+func WithType(name string, prototype interface{}) Option {
+	return func(c *Codec) {
+		var t reflect.Type
+		if prototype != nil {
+			t = elem(reflect.TypeOf(prototype))
+			c.names[t] = name
+		}
+		c.types[name] = t
+	}
+}
+
+// Encode returns a publishing with the encoded value, its ContentType and its Type set.
+// The value type must be registered.
+func (c *Codec) Encode(v interface{}) (amqp.Publishing, error) {
+	if v == nil {
+		return amqp.Publishing{}, fmt.Errorf("value must be not nil")
+	}
+
+	name, ok := c.names[elem(reflect.TypeOf(v))]
+	if !ok {
+		return amqp.Publishing{}, fmt.Errorf("%w: %T", ErrUnknownType, v)
+	}
+
+	body, err := c.marshallers[c.defaultContentType].Marshall(v)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		ContentType: c.defaultContentType,
+		Type:        name,
+		Body:        body,
+	}, nil
+}
+
+// Message returns a publisher message with the encoded value.
+func (c *Codec) Message(ctx context.Context, exchange, key string, v interface{}) (publisher.Message, error) {
+	publishing, err := c.Encode(v)
+	if err != nil {
+		return publisher.Message{}, err
+	}
+
+	return publisher.Message{
+		Context:    ctx,
+		Exchange:   exchange,
+		Key:        key,
+		Publishing: publishing,
+	}, nil
+}
+
+// Publish encodes the value and publishes it.
+func (c *Codec) Publish(ctx context.Context, p *publisher.Publisher, exchange, key string, v interface{}) error {
+	msg, err := c.Message(ctx, exchange, key, v)
+	if err != nil {
+		return err
+	}
+
+	return p.Publish(msg)
+}
+
+// Decode returns a pointer to a new value of the type registered for the delivery Type.
+// A delivery without ContentType is decoded with the default marshaller.
+func (c *Codec) Decode(msg amqp.Delivery) (interface{}, error) {
+	t, ok := c.types[msg.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, msg.Type)
+	}
+
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = c.defaultContentType
+	}
+
+	m, ok := c.marshallers[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+	}
+
+	v := reflect.New(t).Interface()
+	if err := m.Unmarshall(msg.Body, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Handler returns a consumer.Handler decoding the deliveries before calling h.
+// Deliveries which can't be decoded are rejected without requeue, they go to the dead-letter exchange
+// of the queue if any, see rabbitmq.DeclareRetryTopology.
+func (c *Codec) Handler(h HandlerFunc) consumer.Handler {
+	return consumer.HandlerFunc(func(ctx context.Context, msg amqp.Delivery) interface{} {
+		v, err := c.Decode(msg)
+		if err != nil {
+			return consumer.Nack(err)
+		}
+
+		return h(ctx, msg, v)
+	})
+}
+
+func elem(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
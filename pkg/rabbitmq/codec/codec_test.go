@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ereb-or-od/kenobi/pkg/marshalling/json"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/streadway/amqp"
+)
+
+type orderCreated struct {
+	Id string `json:"id"`
+}
+
+func newCodec(t *testing.T) *Codec {
+	t.Helper()
+
+	c, err := New(WithType("order.created", &orderCreated{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCodec_EncodesAndDecodesRegisteredTypes(t *testing.T) {
+	c := newCodec(t)
+
+	p, err := c.Encode(orderCreated{Id: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ContentType != JSONContentType || p.Type != "order.created" || string(p.Body) != `{"id":"1"}` {
+		t.Fatalf("unexpected publishing %s %s %s", p.ContentType, p.Type, p.Body)
+	}
+
+	v, err := c.Decode(amqp.Delivery{ContentType: p.ContentType, Type: p.Type, Body: p.Body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, ok := v.(*orderCreated); !ok || order.Id != "1" {
+		t.Fatalf("unexpected value %#v", v)
+	}
+
+	if _, err = c.Encode(struct{}{}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestCodec_HandlerRejectsMessagesItCantDecode(t *testing.T) {
+	c := newCodec(t)
+
+	called := false
+	h := c.Handler(func(context.Context, amqp.Delivery, interface{}) interface{} {
+		called = true
+		return consumer.Ack()
+	})
+
+	for _, tc := range []struct {
+		name string
+		msg  amqp.Delivery
+		err  error
+	}{
+		{"malformed body", amqp.Delivery{Type: "order.created", Body: []byte(`{"id":`)}, nil},
+		{"unknown type", amqp.Delivery{Type: "order.paid", Body: []byte(`{}`)}, ErrUnknownType},
+		{"unknown content type", amqp.Delivery{Type: "order.created", ContentType: "application/x-protobuf"}, ErrUnknownContentType},
+	} {
+		result, ok := h.Handle(context.Background(), tc.msg).(consumer.Result)
+		if !ok || result.Action != consumer.ActionNack || result.Err == nil {
+			t.Fatalf("%s: unexpected result %v", tc.name, result)
+		}
+		if tc.err != nil && !errors.Is(result.Err, tc.err) {
+			t.Fatalf("%s: unexpected error %v", tc.name, result.Err)
+		}
+	}
+
+	if called {
+		t.Fatal("the handler was called with a message that can't be decoded")
+	}
+
+	// Deliveries without content type are decoded with the default marshaller.
+	if result := h.Handle(context.Background(), amqp.Delivery{Type: "order.created", Body: []byte(`{"id":"1"}`)}); result != consumer.Ack() || !called {
+		t.Fatalf("unexpected result %v", result)
+	}
+}
+
+func TestNew_RejectsAnUnknownDefaultContentType(t *testing.T) {
+	if _, err := New(WithMarshaller(JSONContentType, json.New()), WithDefaultContentType("application/x-protobuf")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := New(WithType("", &orderCreated{})); err == nil {
+		t.Fatal("expected an error for an empty type name")
+	}
+}
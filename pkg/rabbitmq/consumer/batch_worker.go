@@ -0,0 +1,167 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/streadway/amqp"
+)
+
+// BatchHandler handles a batch of deliveries and returns one Result per delivery, in the same order.
+type BatchHandler interface {
+	HandleBatch(ctx context.Context, msgs []amqp.Delivery) []Result
+}
+
+type BatchHandlerFunc func(ctx context.Context, msgs []amqp.Delivery) []Result
+
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, msgs []amqp.Delivery) []Result {
+	return f(ctx, msgs)
+}
+
+// BatchWorker accumulates deliveries up to Size or MaxWait and passes them to the BatchHandler.
+// The Handler of the consumer is not used. A batch is settled with a single ack or nack with multiple=true
+// when all its results have the same action, otherwise every delivery is settled individually.
+// The consumer prefetch count defaults to Size, WithQos must not set it lower.
+type BatchWorker struct {
+	Size    int
+	MaxWait time.Duration
+	Handler BatchHandler
+	Logger  logger.Logger
+
+	// delayedUntil is the time after which all the delayed requeues are done.
+	delayedUntil time.Time
+}
+
+// NewBatchWorker returns a BatchWorker handling batches of size deliveries, or less after maxWait.
+func NewBatchWorker(size int, maxWait time.Duration, handler BatchHandler) *BatchWorker {
+	if size < 1 {
+		panic("batch size must be greater than zero")
+	}
+
+	if maxWait <= 0 {
+		panic("max wait must be greater than zero")
+	}
+
+	if handler == nil {
+		panic("handler must be not nil")
+	}
+
+	return &BatchWorker{
+		Size:    size,
+		MaxWait: maxWait,
+		Handler: handler,
+	}
+}
+
+func (bw *BatchWorker) Serve(ctx context.Context, _ Handler, msgCh <-chan amqp.Delivery) {
+	batch := make([]amqp.Delivery, 0, bw.Size)
+	timer := time.NewTimer(bw.MaxWait)
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			bw.handle(ctx, batch)
+			batch = make([]amqp.Delivery, 0, bw.Size)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(bw.MaxWait)
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				// The consumption was canceled, the last deliveries are handled before returning.
+				if len(batch) > 0 {
+					bw.handle(ctx, batch)
+				}
+				return
+			}
+
+			batch = append(batch, msg)
+			if len(batch) >= bw.Size {
+				flush()
+			}
+		case <-timer.C:
+			if len(batch) > 0 {
+				bw.handle(ctx, batch)
+				batch = make([]amqp.Delivery, 0, bw.Size)
+			}
+			timer.Reset(bw.MaxWait)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (bw *BatchWorker) handle(ctx context.Context, batch []amqp.Delivery) {
+	results := bw.Handler.HandleBatch(ctx, batch)
+	if len(results) != len(batch) {
+		err := fmt.Errorf("batch handler returned %d results for %d deliveries", len(results), len(batch))
+		if bw.Logger != nil {
+			bw.Logger.Error("[ERROR] consumer: batch handler", err)
+		}
+
+		results = make([]Result, len(batch))
+		for i := range results {
+			results[i] = Requeue(err, 0)
+		}
+	}
+
+	if bw.settleMultiple(batch, results) {
+		return
+	}
+
+	for i, msg := range batch {
+		if results[i].Delay > 0 {
+			if until := time.Now().Add(results[i].Delay); until.After(bw.delayedUntil) {
+				bw.delayedUntil = until
+			}
+		}
+
+		settleResult(bw.Logger, msg, results[i])
+	}
+}
+
+// settleMultiple settles the whole batch at once when all the results have the same action without delay.
+// It is not used while delayed requeues are pending because they would be settled too.
+func (bw *BatchWorker) settleMultiple(batch []amqp.Delivery, results []Result) bool {
+	if !time.Now().After(bw.delayedUntil) {
+		return false
+	}
+
+	action := results[0].Action
+	for _, r := range results {
+		if r.Action != action || r.Delay > 0 {
+			return false
+		}
+	}
+
+	last := batch[len(batch)-1]
+
+	var err error
+	switch action {
+	case ActionAck:
+		err = last.Ack(true)
+	case ActionNack:
+		err = last.Nack(true, false)
+	case ActionRequeue:
+		err = last.Nack(true, true)
+	default:
+		return false
+	}
+
+	if err != nil && bw.Logger != nil {
+		bw.Logger.Error("[ERROR] consumer: settle batch", err, map[string]interface{}{"action": action.String(), "size": len(batch)})
+	}
+
+	return true
+}
@@ -0,0 +1,157 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+type (
+	// acknowledger records how the deliveries are settled.
+	acknowledger struct {
+		mu      sync.Mutex
+		settled []string
+	}
+
+	nopLogger struct{}
+)
+
+func (nopLogger) Debug(string, ...map[string]interface{}) {}
+
+func (nopLogger) Info(string, ...map[string]interface{}) {}
+
+func (nopLogger) Warn(string, ...map[string]interface{}) {}
+
+func (nopLogger) Error(string, error, ...map[string]interface{}) {}
+
+func (nopLogger) Fatal(string, error, ...map[string]interface{}) {}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.record("ack", tag, multiple)
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		return a.record("requeue", tag, multiple)
+	}
+	return a.record("nack", tag, multiple)
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *acknowledger) record(action string, tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := fmt.Sprintf("%s %d", action, tag)
+	if multiple {
+		s += " multiple"
+	}
+	a.settled = append(a.settled, s)
+	return nil
+}
+
+// take returns the settlements recorded since the last call.
+func (a *acknowledger) take() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := fmt.Sprint(a.settled)
+	a.settled = nil
+	return s
+}
+
+// deliveries returns a closed chan of the deliveries with the tags from first to last.
+func deliveries(ack amqp.Acknowledger, first, last uint64) <-chan amqp.Delivery {
+	ch := make(chan amqp.Delivery, last-first+1)
+	for tag := first; tag <= last; tag++ {
+		ch <- amqp.Delivery{Acknowledger: ack, DeliveryTag: tag}
+	}
+	close(ch)
+	return ch
+}
+
+// resultsOf returns a BatchHandler returning the given results for the successive batches.
+func resultsOf(batches ...[]Result) BatchHandler {
+	return BatchHandlerFunc(func(_ context.Context, msgs []amqp.Delivery) []Result {
+		results := batches[0]
+		batches = batches[1:]
+		return results
+	})
+}
+
+func TestBatchWorker_SettlesUniformBatchesAtOnce(t *testing.T) {
+	ack := &acknowledger{}
+	bw := NewBatchWorker(3, time.Hour, resultsOf(
+		[]Result{Ack(), Ack(), Ack()},
+		[]Result{Nack(nil), Nack(nil), Nack(nil)},
+		[]Result{Requeue(nil, 0), Requeue(nil, 0)},
+	))
+
+	// The last batch is incomplete, it is handled once the chan is closed.
+	bw.Serve(context.Background(), nil, deliveries(ack, 1, 8))
+
+	if got := ack.take(); got != "[ack 3 multiple nack 6 multiple requeue 8 multiple]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+}
+
+func TestBatchWorker_SettlesMixedAndDelayedResultsOneByOne(t *testing.T) {
+	ack := &acknowledger{}
+	bw := NewBatchWorker(3, time.Hour, resultsOf(
+		[]Result{Ack(), Requeue(errors.New("busy"), 50*time.Millisecond), Nack(nil)},
+		[]Result{Ack(), Ack(), Ack()},
+		[]Result{Ack(), Ack(), Ack()},
+	))
+
+	// A multiple ack of the second batch would settle the delayed delivery too.
+	bw.Serve(context.Background(), nil, deliveries(ack, 1, 6))
+	if got := ack.take(); got != "[ack 1 nack 3 ack 4 ack 5 ack 6]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := ack.take(); got != "[requeue 2]" {
+		t.Fatalf("unexpected delayed settlements %s", got)
+	}
+
+	// Batches are settled at once again when no delayed requeue is pending.
+	bw.Serve(context.Background(), nil, deliveries(ack, 7, 9))
+	if got := ack.take(); got != "[ack 9 multiple]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+}
+
+func TestBatchWorker_RequeuesTheBatchWhenResultsAreMissing(t *testing.T) {
+	ack := &acknowledger{}
+	bw := NewBatchWorker(2, time.Hour, resultsOf([]Result{Ack()}))
+
+	bw.Serve(context.Background(), nil, deliveries(ack, 1, 2))
+
+	if got := ack.take(); got != "[requeue 2 multiple]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+}
+
+func TestNew_DefaultsThePrefetchCountToTheBatchSize(t *testing.T) {
+	c, err := New(make(chan *Connection), WithLogger(nopLogger{}), WithQueue("orders"), WithWorker(NewBatchWorker(10, time.Second, resultsOf())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if c.prefetchCount != 10 {
+		t.Fatalf("prefetch count is %d, want 10", c.prefetchCount)
+	}
+
+	if _, err = New(make(chan *Connection), WithLogger(nopLogger{}), WithQueue("orders"), WithQos(5, false), WithWorker(NewBatchWorker(10, time.Second, resultsOf()))); err == nil {
+		t.Fatal("expected an error for a prefetch count lower than the batch size")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 	"strings"
 	"sync"
@@ -35,6 +36,7 @@ type AMQPChannel interface {
 	NotifyCancel(c chan string) chan string
 	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
 	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Cancel(consumer string, noWait bool) error
 	Close() error
 }
 
@@ -60,6 +62,7 @@ type Consumer struct {
 
 	prefetchCount int
 	qosGlobal     bool
+	qosSet        bool
 
 	exchange   string
 	routingKey string
@@ -80,6 +83,8 @@ type Consumer struct {
 	args      amqp.Table
 
 	retryCounter int
+
+	drainTimeout time.Duration
 }
 
 func New(
@@ -121,10 +126,39 @@ func New(
 	}
 
 	if c.worker == nil {
-		c.worker = &DefaultWorker{}
+		c.worker = &DefaultWorker{Logger: c.logger}
+	}
+
+	// The workers built with their constructor log with the consumer logger.
+	switch w := c.worker.(type) {
+	case *DefaultWorker:
+		if w.Logger == nil {
+			w.Logger = c.logger
+		}
+	case *ParallelWorker:
+		if w.Logger == nil {
+			w.Logger = c.logger
+		}
+	case *PartitionedWorker:
+		if w.Logger == nil {
+			w.Logger = c.logger
+		}
+	case *BatchWorker:
+		if w.Logger == nil {
+			w.Logger = c.logger
+		}
 	}
 
-	if c.handler == nil {
+	// A batch can only be filled when the broker sends enough deliveries before the first one is settled.
+	bw, batching := c.worker.(*BatchWorker)
+	if batching && !c.qosSet {
+		c.prefetchCount = bw.Size
+	} else if batching && c.prefetchCount > 0 && bw.Size > c.prefetchCount {
+		return nil, fmt.Errorf("batch size must be lower than or equal to the prefetch count")
+	}
+
+	// BatchWorker uses its own BatchHandler.
+	if c.handler == nil && !batching {
 		return nil, fmt.Errorf("handler must be not nil")
 	}
 
@@ -183,6 +217,7 @@ func WithQos(prefetchCount int, global bool) Option {
 	return func(c *Consumer) {
 		c.prefetchCount = prefetchCount
 		c.qosGlobal = global
+		c.qosSet = true
 	}
 }
 
@@ -230,6 +265,15 @@ func WithDeclareQueue(name string, durable, autoDelete, exclusive, noWait bool,
 	}
 }
 
+// WithDrain enables the drain mode: on Close the consumer stops receiving new deliveries,
+// lets the in-flight and buffered deliveries be handled for up to timeout then closes the channel.
+// NotifyClosed fires once drained.
+func WithDrain(timeout time.Duration) Option {
+	return func(c *Consumer) {
+		c.drainTimeout = timeout
+	}
+}
+
 func WithHandler(h Handler) Option {
 	return func(c *Consumer) {
 		c.handler = h
@@ -365,9 +409,15 @@ func (c *Consumer) channelState(conn AMQPConnection, connCloseCh <-chan struct{}
 }
 
 func (c *Consumer) consumeState(ch AMQPChannel, queue string, connCloseCh <-chan struct{}) error {
+	tag := c.consumer
+	if tag == "" && c.drainTimeout > 0 {
+		// The tag is needed to cancel the consumption.
+		tag = "ctag-" + uuid.NewString()
+	}
+
 	msgCh, err := ch.Consume(
 		queue,
-		c.consumer,
+		tag,
 		c.autoAck,
 		c.exclusive,
 		c.noLocal,
@@ -384,6 +434,10 @@ func (c *Consumer) consumeState(ch AMQPChannel, queue string, connCloseCh <-chan
 
 	workerDoneCh := make(chan struct{})
 	workerCtx, workerCancelFunc := context.WithCancel(c.ctx)
+	if c.drainTimeout > 0 {
+		// Handlers must not be canceled by Close while draining.
+		workerCtx, workerCancelFunc = context.WithCancel(context.Background())
+	}
 	defer workerCancelFunc()
 
 	c.logger.Debug("[DEBUG] consumer ready")
@@ -414,6 +468,9 @@ func (c *Consumer) consumeState(ch AMQPChannel, queue string, connCloseCh <-chan
 			result = fmt.Errorf("workers unexpectedly stopped")
 		case <-c.ctx.Done():
 			result = nil
+			if c.drainTimeout > 0 {
+				c.drain(ch, tag, workerDoneCh)
+			}
 		}
 
 		workerCancelFunc()
@@ -424,6 +481,26 @@ func (c *Consumer) consumeState(ch AMQPChannel, queue string, connCloseCh <-chan
 	}
 }
 
+// drain cancels the consumption and waits for the workers to handle the remaining deliveries.
+func (c *Consumer) drain(ch AMQPChannel, tag string, workerDoneCh <-chan struct{}) {
+	c.logger.Debug("[DEBUG] consumer draining")
+
+	if err := ch.Cancel(tag, false); err != nil {
+		c.logger.Error("[ERROR] consumer: cancel consumption", err)
+		return
+	}
+
+	timer := time.NewTimer(c.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-workerDoneCh:
+		c.logger.Debug("[DEBUG] consumer drained")
+	case <-timer.C:
+		c.logger.Debug("[DEBUG] consumer drain timeout")
+	}
+}
+
 func (c *Consumer) waitRetry(err error) error {
 	timer := time.NewTimer(c.nextRetryPeriod(c.retryCounter))
 	defer func() {
@@ -8,6 +8,9 @@ import (
 
 type Middleware func(next Handler) Handler
 
+// Handler handles a delivery. The workers settle the Result returned by the handler and nack an error
+// without requeue, so a handler returning an error must not ack or nack the delivery itself.
+// Other results, nil included, leave the delivery to the handler and its middlewares.
 type Handler interface {
 	Handle(ctx context.Context, msg amqp.Delivery) interface{}
 }
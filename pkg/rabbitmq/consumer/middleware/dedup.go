@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/metrics"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/redis/interfaces"
+	"github.com/streadway/amqp"
+)
+
+type (
+	// DedupStore keeps the ids of the handled messages for a while.
+	// Claim atomically reserves an id and returns false when it is already reserved or recorded,
+	// Record keeps a claimed id once its message is handled, Release drops the claim of a failed one.
+	DedupStore interface {
+		Claim(ctx context.Context, id string, ttl time.Duration) (bool, error)
+		Record(ctx context.Context, id string, ttl time.Duration) error
+		Release(ctx context.Context, id string) error
+	}
+
+	redisDedupStore struct {
+		redis  interfaces.RedisServer
+		prefix string
+	}
+
+	// memoryDedupStore is a LRU of ids with a TTL.
+	memoryDedupStore struct {
+		mu      sync.Mutex
+		size    int
+		entries map[string]*list.Element
+		order   *list.List
+	}
+
+	dedupEntry struct {
+		id        string
+		expiresAt time.Time
+	}
+)
+
+// dedupLease bounds the claim of a delivery being handled, so the redelivery of a delivery
+// whose consumer died while handling it is not taken for a duplicate forever.
+const dedupLease = time.Minute
+
+// Dedup acks the deliveries already handled without invoking the handler and counts them with
+// the rabbitmq.consumer.duplicates metric. The id of a delivery is its MessageId unless key is set,
+// consumer.PartitionByHeader could be used to read it from a header. Deliveries without id are always handled.
+// An id is claimed before its delivery is handled, so concurrent redeliveries are handled once,
+// and recorded for ttl only once the handler result acks it; the claim of the other results is released
+// so their redeliveries are handled again. Handlers acking by themselves and returning nil are never recorded.
+// ttl must be greater than zero, the redis store would keep the ids forever otherwise.
+func Dedup(queue string, store DedupStore, ttl time.Duration, key func(msg amqp.Delivery) string, l logger.Logger) (consumer.Middleware, error) {
+	if store == nil {
+		return nil, fmt.Errorf("store must be not nil")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be greater than zero")
+	}
+	if l == nil {
+		return nil, fmt.Errorf("logger must be not nil")
+	}
+
+	if key == nil {
+		key = consumer.PartitionByMessageID
+	}
+
+	lease := dedupLease
+	if ttl < lease {
+		lease = ttl
+	}
+
+	labels := []metrics.Label{{Name: "queue", Value: queue}}
+
+	return wrap(func(ctx context.Context, msg amqp.Delivery, next consumer.Handler) interface{} {
+		id := key(msg)
+		if id == "" {
+			return next.Handle(ctx, msg)
+		}
+
+		// A store failure must not lose the delivery, it is handled as if it was never seen.
+		claimed, err := store.Claim(ctx, id, lease)
+		if err != nil {
+			l.Error("[ERROR] consumer: dedup claim", err, deliveryFields(msg, map[string]interface{}{"queue": queue}))
+			return next.Handle(ctx, msg)
+		}
+
+		if !claimed {
+			metrics.IncrCounterWithLabels([]string{"rabbitmq", "consumer", "duplicates"}, 1, labels)
+			if err := msg.Ack(false); err != nil {
+				l.Error("[ERROR] consumer: dedup ack duplicate", err, deliveryFields(msg, map[string]interface{}{"queue": queue}))
+			}
+			return nil
+		}
+
+		result := next.Handle(ctx, msg)
+
+		if action, handleErr, ok := outcome(result); ok && action == consumer.ActionAck && handleErr == nil {
+			if err := store.Record(ctx, id, ttl); err != nil {
+				l.Error("[ERROR] consumer: dedup record", err, deliveryFields(msg, map[string]interface{}{"queue": queue}))
+			}
+		} else if err := store.Release(ctx, id); err != nil {
+			l.Error("[ERROR] consumer: dedup release", err, deliveryFields(msg, map[string]interface{}{"queue": queue}))
+		}
+
+		return result
+	}), nil
+}
+
+// NewRedisDedupStore returns a DedupStore keeping the ids in redis under the prefix.
+// The ids are claimed with SET NX.
+func NewRedisDedupStore(redis interfaces.RedisServer, prefix string) DedupStore {
+	return &redisDedupStore{
+		redis:  redis,
+		prefix: prefix,
+	}
+}
+
+func (s *redisDedupStore) Claim(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return s.redis.SetValueIfNotExists(ctx, s.prefix+id, true, ttl)
+}
+
+func (s *redisDedupStore) Record(ctx context.Context, id string, ttl time.Duration) error {
+	return s.redis.SetValue(ctx, s.prefix+id, true, ttl)
+}
+
+func (s *redisDedupStore) Release(ctx context.Context, id string) error {
+	return s.redis.DeleteValueByKey(ctx, s.prefix+id)
+}
+
+// NewMemoryDedupStore returns a DedupStore keeping up to size ids in memory, the least recently used are evicted first.
+func NewMemoryDedupStore(size int) DedupStore {
+	if size < 1 {
+		panic("size must be greater than zero")
+	}
+
+	return &memoryDedupStore{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (s *memoryDedupStore) Claim(_ context.Context, id string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[id]; ok {
+		if time.Now().Before(e.Value.(*dedupEntry).expiresAt) {
+			s.order.MoveToFront(e)
+			return false, nil
+		}
+
+		s.order.Remove(e)
+		delete(s.entries, id)
+	}
+
+	s.set(id, ttl)
+	return true, nil
+}
+
+func (s *memoryDedupStore) Record(_ context.Context, id string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(id, ttl)
+	return nil
+}
+
+func (s *memoryDedupStore) Release(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[id]; ok {
+		s.order.Remove(e)
+		delete(s.entries, id)
+	}
+
+	return nil
+}
+
+// set records the id for ttl and evicts the least recently used ids, s.mu must be held.
+func (s *memoryDedupStore) set(id string, ttl time.Duration) {
+	if e, ok := s.entries[id]; ok {
+		e.Value.(*dedupEntry).expiresAt = time.Now().Add(ttl)
+		s.order.MoveToFront(e)
+		return
+	}
+
+	s.entries[id] = s.order.PushFront(&dedupEntry{id: id, expiresAt: time.Now().Add(ttl)})
+
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*dedupEntry).id)
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/streadway/amqp"
+)
+
+func TestDedup_RejectsANonPositiveTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := Dedup("orders", NewMemoryDedupStore(10), ttl, nil, &recordingLogger{}); err == nil {
+			t.Fatalf("expected an error for ttl %s", ttl)
+		}
+	}
+}
+
+func TestDedup_AcksDuplicatesWithoutHandlingThem(t *testing.T) {
+	dedup, err := Dedup("orders", NewMemoryDedupStore(10), time.Hour, nil, &recordingLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled []string
+	h := dedup(consumer.HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		handled = append(handled, msg.MessageId)
+		return consumer.Ack()
+	}))
+
+	ack := &acknowledger{}
+	for i, id := range []string{"1", "2", "1", ""} {
+		h.Handle(context.Background(), delivery(ack, uint64(i+1), id))
+	}
+
+	// The duplicate is acked by the middleware, the results of the others are settled by the worker.
+	if got := ack.String(); got != "[ack 3]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+	// Deliveries without id are always handled.
+	if len(handled) != 3 || handled[0] != "1" || handled[1] != "2" || handled[2] != "" {
+		t.Fatalf("unexpected handled messages %q", handled)
+	}
+}
+
+func TestDedup_ReleasesTheClaimOfFailedDeliveries(t *testing.T) {
+	dedup, err := Dedup("orders", NewMemoryDedupStore(10), time.Hour, nil, &recordingLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []interface{}{
+		errors.New("failed"),
+		consumer.Requeue(errors.New("busy"), 0),
+		consumer.Ack(),
+		consumer.Ack(),
+	}
+
+	calls := 0
+	h := dedup(consumer.HandlerFunc(func(context.Context, amqp.Delivery) interface{} {
+		result := results[calls]
+		calls++
+		return result
+	}))
+
+	// The redeliveries of the failed deliveries are handled again, until one is acked.
+	ack := &acknowledger{}
+	for i := 0; i < 4; i++ {
+		h.Handle(context.Background(), delivery(ack, uint64(i+1), "1"))
+	}
+
+	if calls != 3 {
+		t.Fatalf("handler called %d times, want 3", calls)
+	}
+	if got := ack.String(); got != "[ack 4]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/streadway/amqp"
+)
+
+// Logging logs the failed deliveries: results nacking or requeuing them, results carrying an error and panics.
+// Panics are logged and raised again.
+func Logging(l logger.Logger) consumer.Middleware {
+	return wrap(func(ctx context.Context, msg amqp.Delivery, next consumer.Handler) (result interface{}) {
+		defer func() {
+			if e := recover(); e != nil {
+				l.Error("[ERROR] consumer: handler panic", fmt.Errorf("%v", e), deliveryFields(msg, nil))
+				panic(e)
+			}
+		}()
+
+		result = next.Handle(ctx, msg)
+
+		if action, err, ok := outcome(result); ok && (action != consumer.ActionAck || err != nil) {
+			if err == nil {
+				err = fmt.Errorf("delivery not acknowledged")
+			}
+			l.Error("[ERROR] consumer: handle delivery", err, deliveryFields(msg, map[string]interface{}{"action": action.String()}))
+		}
+
+		return result
+	})
+}
+
+// outcome returns the action and the error of a handler result, ok is false when it is unknown.
+func outcome(result interface{}) (consumer.Action, error, bool) {
+	switch r := result.(type) {
+	case consumer.Result:
+		return r.Action, r.Err, true
+	case error:
+		return consumer.ActionNack, r, true
+	case string:
+		switch r {
+		case Ack:
+			return consumer.ActionAck, nil, true
+		case Nack:
+			return consumer.ActionNack, nil, true
+		case Requeue:
+			return consumer.ActionRequeue, nil, true
+		}
+	}
+
+	return consumer.ActionAck, nil, false
+}
+
+func deliveryFields(msg amqp.Delivery, fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+
+	fields["exchange"] = msg.Exchange
+	fields["routing_key"] = msg.RoutingKey
+	fields["message_id"] = msg.MessageId
+	fields["correlation_id"] = msg.CorrelationId
+	fields["redelivered"] = msg.Redelivered
+
+	return fields
+}
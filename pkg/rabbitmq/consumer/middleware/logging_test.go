@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/streadway/amqp"
+)
+
+func returning(result interface{}) consumer.Handler {
+	return consumer.HandlerFunc(func(context.Context, amqp.Delivery) interface{} {
+		return result
+	})
+}
+
+func panicking(v interface{}) consumer.Handler {
+	return consumer.HandlerFunc(func(context.Context, amqp.Delivery) interface{} {
+		panic(v)
+	})
+}
+
+func TestLogging_LogsFailedDeliveries(t *testing.T) {
+	for _, tc := range []struct {
+		result interface{}
+		logged string
+	}{
+		{consumer.Ack(), "[]"},
+		{Ack, "[]"},
+		{nil, "[]"},
+		{errors.New("invalid"), "[[ERROR] consumer: handle delivery]"},
+		{consumer.Nack(nil), "[[ERROR] consumer: handle delivery]"},
+		{consumer.Requeue(nil, 0), "[[ERROR] consumer: handle delivery]"},
+		{consumer.Result{Action: consumer.ActionAck, Err: errors.New("partial")}, "[[ERROR] consumer: handle delivery]"},
+		{Requeue, "[[ERROR] consumer: handle delivery]"},
+	} {
+		l := &recordingLogger{}
+		result := Logging(l)(returning(tc.result)).Handle(context.Background(), amqp.Delivery{})
+
+		if got := l.String(); got != tc.logged {
+			t.Fatalf("result %v: logged %s, want %s", tc.result, got, tc.logged)
+		}
+		if result != tc.result {
+			t.Fatalf("result %v was changed to %v", tc.result, result)
+		}
+	}
+}
+
+func TestLogging_LogsAndRaisesPanics(t *testing.T) {
+	l := &recordingLogger{}
+
+	defer func() {
+		if e := recover(); e != "panic: boom" {
+			t.Fatalf("unexpected panic %v", e)
+		}
+		if got := l.String(); got != "[[ERROR] consumer: handler panic]" {
+			t.Fatalf("unexpected logs %s", got)
+		}
+	}()
+
+	Logging(l)(panicking("panic: boom")).Handle(context.Background(), amqp.Delivery{})
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/metrics"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/streadway/amqp"
+)
+
+// Metrics emits per-queue metrics through pkg/metrics: processed, failed and requeued counters
+// and the handler duration. Panics are counted as failures and raised again.
+func Metrics(queue string) consumer.Middleware {
+	labels := []metrics.Label{{Name: "queue", Value: queue}}
+
+	return wrap(func(ctx context.Context, msg amqp.Delivery, next consumer.Handler) (result interface{}) {
+		start := time.Now()
+
+		defer func() {
+			metrics.MeasureSinceWithLabels([]string{"rabbitmq", "consumer", "duration"}, start, labels)
+			metrics.IncrCounterWithLabels([]string{"rabbitmq", "consumer", "processed"}, 1, labels)
+
+			if e := recover(); e != nil {
+				metrics.IncrCounterWithLabels([]string{"rabbitmq", "consumer", "failed"}, 1, labels)
+				panic(e)
+			}
+
+			action, err, _ := outcome(result)
+			switch {
+			case action == consumer.ActionRequeue:
+				metrics.IncrCounterWithLabels([]string{"rabbitmq", "consumer", "requeued"}, 1, labels)
+			case action == consumer.ActionNack || err != nil:
+				metrics.IncrCounterWithLabels([]string{"rabbitmq", "consumer", "failed"}, 1, labels)
+			}
+		}()
+
+		return next.Handle(ctx, msg)
+	})
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ereb-or-od/kenobi/pkg/metrics"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/streadway/amqp"
+)
+
+// counterSink counts the increments of the counters by name and labels.
+type counterSink struct {
+	metrics.EmptySink
+
+	mu       sync.Mutex
+	counters map[string]float32
+}
+
+func (s *counterSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.Join(key, ".")
+	for _, l := range labels {
+		name += " " + l.Name + "=" + l.Value
+	}
+	s.counters[name] += val
+}
+
+func (s *counterSink) counter(name string) float32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[name]
+}
+
+func useCounterSink(t *testing.T) *counterSink {
+	t.Helper()
+
+	sink := &counterSink{counters: make(map[string]float32)}
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+
+	return sink
+}
+
+func TestMetrics_CountsProcessedFailedAndRequeuedDeliveries(t *testing.T) {
+	sink := useCounterSink(t)
+	h := Metrics("orders")
+
+	for _, result := range []interface{}{
+		consumer.Ack(),
+		nil,
+		errors.New("invalid"),
+		consumer.Nack(nil),
+		consumer.Requeue(errors.New("busy"), 0),
+		Requeue,
+	} {
+		h(returning(result)).Handle(context.Background(), amqp.Delivery{})
+	}
+
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Fatal("the panic was not raised again")
+			}
+		}()
+		h(panicking("boom")).Handle(context.Background(), amqp.Delivery{})
+	}()
+
+	for name, want := range map[string]float32{
+		"rabbitmq.consumer.processed queue=orders": 7,
+		"rabbitmq.consumer.failed queue=orders":    3,
+		"rabbitmq.consumer.requeued queue=orders":  2,
+	} {
+		if got := sink.counter(name); got != want {
+			t.Fatalf("%s is %v, want %v", name, got, want)
+		}
+	}
+}
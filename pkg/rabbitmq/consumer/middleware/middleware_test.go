@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+type (
+	// acknowledger records how the deliveries are settled.
+	acknowledger struct {
+		mu      sync.Mutex
+		settled []string
+	}
+
+	recordingLogger struct {
+		mu       sync.Mutex
+		messages []string
+	}
+)
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.record(fmt.Sprintf("ack %d", tag))
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		return a.record(fmt.Sprintf("requeue %d", tag))
+	}
+	return a.record(fmt.Sprintf("nack %d", tag))
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *acknowledger) record(s string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.settled = append(a.settled, s)
+	return nil
+}
+
+func (a *acknowledger) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return fmt.Sprint(a.settled)
+}
+
+func delivery(ack amqp.Acknowledger, tag uint64, id string) amqp.Delivery {
+	return amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, MessageId: id}
+}
+
+func (l *recordingLogger) Debug(msg string, _ ...map[string]interface{}) {
+	l.record(msg)
+}
+
+func (l *recordingLogger) Info(msg string, _ ...map[string]interface{}) {
+	l.record(msg)
+}
+
+func (l *recordingLogger) Warn(msg string, _ ...map[string]interface{}) {
+	l.record(msg)
+}
+
+func (l *recordingLogger) Error(msg string, _ error, _ ...map[string]interface{}) {
+	l.record(msg)
+}
+
+func (l *recordingLogger) Fatal(msg string, _ error, _ ...map[string]interface{}) {
+	l.record(msg)
+}
+
+func (l *recordingLogger) record(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, msg)
+}
+
+func (l *recordingLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fmt.Sprint(l.messages)
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+// RetryCountHeader is the header holding the number of times a message was retried.
+const RetryCountHeader = "x-retry-count"
+
+// RetryPublishDelay is how long Retry waits before requeuing a failed delivery whose copy could not be published.
+const RetryPublishDelay = 5 * time.Second
+
+// Retry routes the failed deliveries to the retry queue with an incremented RetryCountHeader
+// until maxRetries is reached, then to the parking-lot queue. A delivery fails when the handler
+// returns Nack, a nacking consumer.Result or an error, or panics.
+// The publisher must be created with confirmation: the original delivery is acked once the broker
+// confirmed the copy, otherwise the worker requeues it after RetryPublishDelay so a message is never lost
+// between the two and an unavailable broker is not retried in a loop.
+// The queues could be declared with rabbitmq.DeclareRetryTopology.
+func Retry(p *publisher.Publisher, retryQueue, parkingLotQueue string, maxRetries int, l logger.Logger) (consumer.Middleware, error) {
+	if p == nil {
+		return nil, fmt.Errorf("publisher must be not nil")
+	}
+	if !p.Confirming() {
+		return nil, fmt.Errorf("publisher must be created with confirmation")
+	}
+	if l == nil {
+		return nil, fmt.Errorf("logger must be not nil")
+	}
+
+	return wrap(func(ctx context.Context, msg amqp.Delivery, next consumer.Handler) interface{} {
+		result, failed := handle(ctx, msg, next, l)
+		if !failed {
+			return result
+		}
+
+		count := RetryCount(msg)
+		queue := retryQueue
+		if count >= maxRetries {
+			queue = parkingLotQueue
+		}
+
+		// Publish returns once the copy is confirmed by the broker.
+		if err := p.Publish(publisher.Message{
+			Context:      ctx,
+			Key:          queue,
+			ErrOnUnready: true,
+			Publishing:   retryPublishing(msg, count+1),
+		}); err != nil {
+			l.Error("[ERROR] consumer: publish retry", err, deliveryFields(msg, map[string]interface{}{"queue": queue}))
+			return consumer.Requeue(err, RetryPublishDelay)
+		}
+
+		if ackErr := msg.Ack(false); ackErr != nil {
+			l.Error("[ERROR] consumer: ack retried delivery", ackErr, deliveryFields(msg, map[string]interface{}{"queue": queue}))
+		}
+		return nil
+	}), nil
+}
+
+// RetryCount returns the value of the RetryCountHeader of the delivery, 0 when missing.
+func RetryCount(msg amqp.Delivery) int {
+	switch v := msg.Headers[RetryCountHeader].(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func handle(ctx context.Context, msg amqp.Delivery, next consumer.Handler, l logger.Logger) (result interface{}, failed bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			l.Error("[ERROR] consumer: handler panic", fmt.Errorf("%v", e), deliveryFields(msg, nil))
+			result, failed = nil, true
+		}
+	}()
+
+	result = next.Handle(ctx, msg)
+	action, _, _ := outcome(result)
+	return result, action == consumer.ActionNack
+}
+
+func retryPublishing(msg amqp.Delivery, count int) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[RetryCountHeader] = int32(count)
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/amqptest"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+// newRetry returns the Retry middleware publishing through a dialer of the fake broker,
+// once its publisher is ready when ready is set.
+func newRetry(t *testing.T, b *amqptest.Broker, ready bool, opts ...publisher.Option) consumer.Middleware {
+	t.Helper()
+
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(amqptest.DialerInitFunc),
+		rabbitmq.WithRetryPeriod(10*time.Millisecond),
+		rabbitmq.WithLogger(&recordingLogger{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, queue := range []string{"orders.retry", "orders.parking"} {
+		if _, err = rabbitmq.Queue(ctx, d, queue, true, false, false, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := d.Publisher(append([]publisher.Option{publisher.WithConfirmation(1)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	for stateCh := p.Notify(make(chan publisher.State, 1)); ready; {
+		select {
+		case state := <-stateCh:
+			ready = state.Ready == nil
+		case <-ctx.Done():
+			t.Fatal("publisher is not ready")
+		}
+	}
+
+	retry, err := Retry(p, "orders.retry", "orders.parking", 2, &recordingLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return retry
+}
+
+func retried(count int) amqp.Delivery {
+	msg := amqp.Delivery{MessageId: "1", DeliveryTag: 1}
+	if count > 0 {
+		msg.Headers = amqp.Table{RetryCountHeader: int32(count)}
+	}
+	return msg
+}
+
+func TestRetry_RepublishesFailedDeliveriesWithAnIncrementedCount(t *testing.T) {
+	b := amqptest.NewBroker()
+	h := newRetry(t, b, true)(returning(errors.New("invalid")))
+
+	ack := &acknowledger{}
+	for count := 0; count < 3; count++ {
+		msg := retried(count)
+		msg.Acknowledger = ack
+
+		if result := h.Handle(context.Background(), msg); result != nil {
+			t.Fatalf("unexpected result %v", result)
+		}
+	}
+
+	// The copies are confirmed before the originals are acked.
+	if got := ack.String(); got != "[ack 1 ack 1 ack 1]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+
+	// The third failure reached maxRetries.
+	if n := b.QueueLen("orders.retry"); n != 2 {
+		t.Fatalf("%d messages in the retry queue, want 2", n)
+	}
+	if n := b.QueueLen("orders.parking"); n != 1 {
+		t.Fatalf("%d messages in the parking-lot queue, want 1", n)
+	}
+}
+
+func TestRetry_PassesSuccessfulResultsThrough(t *testing.T) {
+	b := amqptest.NewBroker()
+	h := newRetry(t, b, true)(returning(consumer.Ack()))
+
+	ack := &acknowledger{}
+	msg := retried(1)
+	msg.Acknowledger = ack
+
+	if result := h.Handle(context.Background(), msg); result != consumer.Ack() {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if got := ack.String(); got != "[]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+	if n := b.QueueLen("orders.retry") + b.QueueLen("orders.parking"); n != 0 {
+		t.Fatalf("%d messages republished", n)
+	}
+}
+
+func TestRetry_DelaysTheRequeueWhenTheCopyIsNotPublished(t *testing.T) {
+	b := amqptest.NewBroker()
+
+	// The publisher never gets a channel.
+	h := newRetry(t, b, false, publisher.WithInitFunc(func(publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+		return nil, errors.New("channel unavailable")
+	}))(returning(errors.New("invalid")))
+
+	ack := &acknowledger{}
+	msg := retried(0)
+	msg.Acknowledger = ack
+
+	result, ok := h.Handle(context.Background(), msg).(consumer.Result)
+	if !ok || result.Action != consumer.ActionRequeue || result.Delay != RetryPublishDelay || result.Err == nil {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if got := ack.String(); got != "[]" {
+		t.Fatalf("the delivery was settled: %s", got)
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		count int
+	}{
+		{nil, 0},
+		{int32(3), 3},
+		{int64(4), 4},
+		{int(5), 5},
+		{"6", 0},
+	} {
+		msg := amqp.Delivery{Headers: amqp.Table{RetryCountHeader: tc.value}}
+		if got := RetryCount(msg); got != tc.count {
+			t.Fatalf("retry count of %v is %d, want %d", tc.value, got, tc.count)
+		}
+	}
+}
+
+func TestRetryPublishing_IncrementsTheCountAndKeepsTheOtherHeaders(t *testing.T) {
+	msg := retried(1)
+	msg.Headers["tenant"] = "acme"
+
+	p := retryPublishing(msg, RetryCount(msg)+1)
+
+	if RetryCount(amqp.Delivery{Headers: p.Headers}) != 2 || p.Headers["tenant"] != "acme" || p.MessageId != "1" {
+		t.Fatalf("unexpected publishing %v %s", p.Headers, p.MessageId)
+	}
+	if RetryCount(msg) != 1 {
+		t.Fatal("the headers of the delivery were changed")
+	}
+}
@@ -0,0 +1,124 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
+	"github.com/streadway/amqp"
+)
+
+// PartitionKey returns the key used to choose the partition of a delivery.
+type PartitionKey func(msg amqp.Delivery) string
+
+// PartitionedWorker hashes the key of every delivery to a fixed goroutine.
+// Deliveries with the same key are handled in order, deliveries with different keys could run in parallel.
+// Every partition has a bounded queue, a full queue blocks the dispatch of the next deliveries.
+// The consumer prefetch count should be greater than the number of partitions to keep them busy.
+type PartitionedWorker struct {
+	Num       int
+	QueueSize int
+	Key       PartitionKey
+	Logger    logger.Logger
+}
+
+// NewPartitionedWorker returns a PartitionedWorker with num partitions of queueSize deliveries.
+func NewPartitionedWorker(num, queueSize int, key PartitionKey) *PartitionedWorker {
+	if num < 1 {
+		panic("num partitions must be greater than zero")
+	}
+
+	if queueSize < 0 {
+		panic("queue size must be positive")
+	}
+
+	if key == nil {
+		panic("key must be not nil")
+	}
+
+	return &PartitionedWorker{
+		Num:       num,
+		QueueSize: queueSize,
+		Key:       key,
+	}
+}
+
+// PartitionByRoutingKey uses the routing key of the delivery as partition key.
+func PartitionByRoutingKey(msg amqp.Delivery) string {
+	return msg.RoutingKey
+}
+
+// PartitionByMessageID uses the message id of the delivery as partition key.
+func PartitionByMessageID(msg amqp.Delivery) string {
+	return msg.MessageId
+}
+
+// PartitionByHeader uses the value of a header as partition key.
+func PartitionByHeader(name string) PartitionKey {
+	return func(msg amqp.Delivery) string {
+		v, ok := msg.Headers[name]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+func (pw *PartitionedWorker) Serve(ctx context.Context, h Handler, msgCh <-chan amqp.Delivery) {
+	partitions := make([]chan amqp.Delivery, pw.Num)
+	wg := &sync.WaitGroup{}
+
+	for i := range partitions {
+		partitions[i] = make(chan amqp.Delivery, pw.QueueSize)
+
+		wg.Add(1)
+		go func(partitionCh <-chan amqp.Delivery) {
+			defer wg.Done()
+
+			for {
+				select {
+				case msg, ok := <-partitionCh:
+					if !ok {
+						return
+					}
+
+					settle(pw.Logger, msg, h.Handle(ctx, msg))
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(partitions[i])
+	}
+
+	defer func() {
+		for _, partitionCh := range partitions {
+			close(partitionCh)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+
+			select {
+			case partitions[pw.partition(msg)] <- msg:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (pw *PartitionedWorker) partition(msg amqp.Delivery) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(pw.Key(msg)))
+	return int(hash.Sum32() % uint32(pw.Num))
+}
@@ -0,0 +1,107 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestPartitionedWorker_HandlesDeliveriesOfAKeyInOrder(t *testing.T) {
+	ack := &acknowledger{}
+	pw := NewPartitionedWorker(3, 2, PartitionByRoutingKey)
+	pw.Logger = nopLogger{}
+
+	msgCh := make(chan amqp.Delivery, 60)
+	for tag := uint64(1); tag <= 60; tag++ {
+		msgCh <- amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, RoutingKey: fmt.Sprintf("order.%d", tag%5)}
+	}
+	close(msgCh)
+
+	var mu sync.Mutex
+	running := make(map[string]bool)
+	handled := make(map[string][]uint64)
+
+	pw.Serve(context.Background(), HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		mu.Lock()
+		if running[msg.RoutingKey] {
+			mu.Unlock()
+			return fmt.Errorf("%s is already handled", msg.RoutingKey)
+		}
+		running[msg.RoutingKey] = true
+		mu.Unlock()
+
+		time.Sleep(time.Duration(msg.DeliveryTag%3) * time.Millisecond)
+
+		mu.Lock()
+		running[msg.RoutingKey] = false
+		handled[msg.RoutingKey] = append(handled[msg.RoutingKey], msg.DeliveryTag)
+		mu.Unlock()
+
+		return Ack()
+	}), msgCh)
+
+	// Serve returns once the queued deliveries are handled.
+	settled := ack.take()
+	if strings.Contains(settled, "nack") {
+		t.Fatalf("unexpected settlements %s", settled)
+	}
+	if got := strings.Count(settled, "ack"); got != 60 {
+		t.Fatalf("%d deliveries acked, want 60", got)
+	}
+
+	for key, tags := range handled {
+		if len(tags) != 12 {
+			t.Fatalf("%s handled %d deliveries, want 12", key, len(tags))
+		}
+		for i := 1; i < len(tags); i++ {
+			if tags[i] < tags[i-1] {
+				t.Fatalf("%s handled out of order %v", key, tags)
+			}
+		}
+	}
+}
+
+func TestPartitionedWorker_HandlesPartitionsInParallel(t *testing.T) {
+	ack := &acknowledger{}
+	pw := NewPartitionedWorker(2, 1, PartitionByMessageID)
+	pw.Logger = nopLogger{}
+
+	// Find two keys of different partitions.
+	first := amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, MessageId: "a"}
+	second := amqp.Delivery{Acknowledger: ack, DeliveryTag: 2}
+	for i := 0; second.MessageId == ""; i++ {
+		if key := fmt.Sprint(i); pw.partition(amqp.Delivery{MessageId: key}) != pw.partition(first) {
+			second.MessageId = key
+		}
+	}
+
+	msgCh := make(chan amqp.Delivery, 2)
+	msgCh <- first
+	msgCh <- second
+	close(msgCh)
+
+	// The first delivery waits for the second one.
+	secondCh := make(chan struct{})
+	pw.Serve(context.Background(), HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		if msg.DeliveryTag == 2 {
+			close(secondCh)
+			return Ack()
+		}
+
+		select {
+		case <-secondCh:
+			return Ack()
+		case <-time.After(time.Second):
+			return fmt.Errorf("partitions handled one after the other")
+		}
+	}), msgCh)
+
+	if got := ack.take(); got != "[ack 2 ack 1]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Action tells how a delivery is settled.
+type Action int
+
+const (
+	// ActionAck acknowledges the delivery.
+	ActionAck Action = iota
+	// ActionNack rejects the delivery without requeue, it is dead-lettered when the queue has a DLX.
+	ActionNack
+	// ActionRequeue rejects the delivery and puts it back in the queue.
+	ActionRequeue
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAck:
+		return "ack"
+	case ActionNack:
+		return "nack"
+	case ActionRequeue:
+		return "requeue"
+	default:
+		return "unknown"
+	}
+}
+
+// Result could be returned by a Handler, workers settle the delivery according to it.
+type Result struct {
+	Action Action
+	// Err is the reason of a failure.
+	Err error
+	// Delay postpones the requeue, the delivery stays unacknowledged meanwhile.
+	Delay time.Duration
+}
+
+// Ack returns a Result acknowledging the delivery.
+func Ack() Result {
+	return Result{Action: ActionAck}
+}
+
+// Nack returns a Result rejecting the delivery without requeue.
+func Nack(err error) Result {
+	return Result{Action: ActionNack, Err: err}
+}
+
+// Requeue returns a Result putting back the delivery in the queue after the delay.
+func Requeue(err error, delay time.Duration) Result {
+	return Result{Action: ActionRequeue, Err: err, Delay: delay}
+}
+
+// Failed tells if the delivery was not acknowledged or an error is set.
+func (r Result) Failed() bool {
+	return r.Action != ActionAck || r.Err != nil
+}
+
+// Settle acks, nacks or requeues the delivery. The error of a delayed requeue is lost, the workers log it.
+func (r Result) Settle(msg amqp.Delivery) error {
+	return r.settle(msg, nil)
+}
+
+// settle is Settle passing the error of a delayed requeue to onDelayedErr.
+func (r Result) settle(msg amqp.Delivery, onDelayedErr func(err error)) error {
+	switch r.Action {
+	case ActionAck:
+		return msg.Ack(false)
+	case ActionNack:
+		return msg.Nack(false, false)
+	case ActionRequeue:
+		if r.Delay > 0 {
+			time.AfterFunc(r.Delay, func() {
+				if err := msg.Nack(false, true); err != nil && onDelayedErr != nil {
+					onDelayedErr(err)
+				}
+			})
+			return nil
+		}
+		return msg.Nack(false, true)
+	default:
+		return nil
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"github.com/streadway/amqp"
 )
 
+// Worker passes the deliveries to the handler. The workers of this package settle the handler results, see Handler.
 type Worker interface {
 	Serve(ctx context.Context, h Handler, msgCh <-chan amqp.Delivery)
 }
 
 type DefaultWorker struct {
+	Logger logger.Logger
 }
 
 func (dw *DefaultWorker) Serve(ctx context.Context, h Handler, msgCh <-chan amqp.Delivery) {
@@ -23,9 +25,7 @@ func (dw *DefaultWorker) Serve(ctx context.Context, h Handler, msgCh <-chan amqp
 				return
 			}
 
-			if res := h.Handle(ctx, msg); res != nil {
-
-			}
+			settle(dw.Logger, msg, h.Handle(ctx, msg))
 		case <-ctx.Done():
 			return
 		}
@@ -61,8 +61,7 @@ func (pw *ParallelWorker) Serve(ctx context.Context, h Handler, msgCh <-chan amq
 						return
 					}
 
-					if res := h.Handle(ctx, msg); res != nil {
-					}
+					settle(pw.Logger, msg, h.Handle(ctx, msg))
 				case <-ctx.Done():
 					return
 				}
@@ -72,3 +71,30 @@ func (pw *ParallelWorker) Serve(ctx context.Context, h Handler, msgCh <-chan amq
 
 	wg.Wait()
 }
+
+// settle applies the Result returned by a handler, an error is settled as Nack(err).
+// Other results are expected to be handled by middlewares.
+func settle(l logger.Logger, msg amqp.Delivery, res interface{}) {
+	switch r := res.(type) {
+	case Result:
+		settleResult(l, msg, r)
+	case error:
+		settleResult(l, msg, Nack(r))
+	}
+}
+
+// settleResult settles the delivery and logs the failures, delayed requeues included.
+func settleResult(l logger.Logger, msg amqp.Delivery, result Result) {
+	logErr := func(err error) {
+		if l != nil {
+			l.Error("[ERROR] consumer: settle delivery", err, map[string]interface{}{
+				"action":       result.Action.String(),
+				"delivery_tag": msg.DeliveryTag,
+			})
+		}
+	}
+
+	if err := result.settle(msg, logErr); err != nil {
+		logErr(err)
+	}
+}
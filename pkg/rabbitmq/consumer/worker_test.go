@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// resultsByTag returns a Handler returning the result of the delivery tag.
+func resultsByTag(results map[uint64]interface{}) Handler {
+	return HandlerFunc(func(_ context.Context, msg amqp.Delivery) interface{} {
+		return results[msg.DeliveryTag]
+	})
+}
+
+func TestResult_Settle(t *testing.T) {
+	ack := &acknowledger{}
+	msg := amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}
+
+	for _, r := range []Result{Ack(), Nack(errors.New("invalid")), Requeue(nil, 0), Requeue(nil, 30*time.Millisecond)} {
+		if err := r.Settle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := ack.take(); got != "[ack 1 nack 1 requeue 1]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := ack.take(); got != "[requeue 1]" {
+		t.Fatalf("unexpected delayed settlements %s", got)
+	}
+}
+
+func TestResult_Failed(t *testing.T) {
+	for _, tc := range []struct {
+		result Result
+		failed bool
+	}{
+		{Ack(), false},
+		{Result{Action: ActionAck, Err: errors.New("partial")}, true},
+		{Nack(nil), true},
+		{Requeue(nil, 0), true},
+	} {
+		if tc.result.Failed() != tc.failed {
+			t.Fatalf("%s with error %v: failed is %v", tc.result.Action, tc.result.Err, !tc.failed)
+		}
+	}
+}
+
+func TestDefaultWorker_SettlesResultsAndErrors(t *testing.T) {
+	ack := &acknowledger{}
+	w := &DefaultWorker{Logger: nopLogger{}}
+
+	w.Serve(context.Background(), resultsByTag(map[uint64]interface{}{
+		1: Ack(),
+		2: errors.New("invalid"),
+		3: nil,
+		4: Requeue(errors.New("busy"), 30*time.Millisecond),
+		5: "unknown",
+		6: Requeue(nil, 0),
+	}), deliveries(ack, 1, 6))
+
+	// nil and unknown results are left to the handler.
+	if got := ack.take(); got != "[ack 1 nack 2 requeue 6]" {
+		t.Fatalf("unexpected settlements %s", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := ack.take(); got != "[requeue 4]" {
+		t.Fatalf("unexpected delayed settlements %s", got)
+	}
+}
+
+func TestParallelWorker_SettlesResultsAndErrors(t *testing.T) {
+	ack := &acknowledger{}
+	w := NewParallelWorker(3)
+
+	w.Serve(context.Background(), resultsByTag(map[uint64]interface{}{
+		1: Ack(),
+		2: errors.New("invalid"),
+		3: nil,
+		4: Nack(nil),
+	}), deliveries(ack, 1, 4))
+
+	ack.mu.Lock()
+	got := append([]string(nil), ack.settled...)
+	ack.mu.Unlock()
+
+	sort.Strings(got)
+	if len(got) != 3 || got[0] != "ack 1" || got[1] != "nack 2" || got[2] != "nack 4" {
+		t.Fatalf("unexpected settlements %v", got)
+	}
+}
@@ -3,7 +3,6 @@ package rabbitmq
 import (
 	"context"
 	"github.com/streadway/amqp"
-	"log"
 )
 
 func TempQueue(
@@ -32,18 +31,13 @@ func Queue(
 	noWait bool,
 	args amqp.Table,
 ) (amqp.Queue, error) {
-	conn, err := c.Connection(ctx)
-	if err != nil {
-		return amqp.Queue{}, err
-	}
-
-	ch, err := conn.Channel()
+	ch, err := c.Channel(ctx)
 	if err != nil {
 		return amqp.Queue{}, err
 	}
 	defer func() {
 		if closeErr := ch.Close(); closeErr != nil {
-			log.Print("amqpextra: declare queue: ch close: %w", closeErr)
+			c.logger.Error("[ERROR] declare queue: channel close", closeErr)
 		}
 	}()
 
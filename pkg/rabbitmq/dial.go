@@ -0,0 +1,116 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// CredentialsProvider returns the username and password used by the next dial attempt.
+type CredentialsProvider func(ctx context.Context) (username, password string, err error)
+
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	jitter  float64
+}
+
+// Backoff returns a retry period func doubling from initial up to max, or a configuration error.
+// Each period is reduced by a random part of up to jitter (0..1) of it, so reconnecting clients are spread out.
+func Backoff(initial, max time.Duration, jitter float64) (func(retryCount int) time.Duration, error) {
+	b := &backoff{initial: initial, max: max, jitter: jitter}
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	return b.next, nil
+}
+
+// TLSConfig returns a TLS config trusting the CA of caFile and presenting the client certificate of certFile and keyFile.
+// Empty files are skipped, the system CAs are used without caFile.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func (b *backoff) validate() error {
+	if b.initial <= 0 {
+		return fmt.Errorf("backoff initial period must be greater than zero")
+	}
+
+	if b.max < b.initial {
+		return fmt.Errorf("backoff max period must be greater than or equal to the initial period")
+	}
+
+	if b.jitter < 0 || b.jitter > 1 {
+		return fmt.Errorf("backoff jitter must be between 0 and 1")
+	}
+
+	return nil
+}
+
+func (b *backoff) next(retryCount int) time.Duration {
+	d := b.initial
+	for i := 0; i < retryCount && d < b.max; i++ {
+		d *= 2
+	}
+
+	if d > b.max {
+		d = b.max
+	}
+
+	if b.jitter > 0 {
+		d -= time.Duration(b.jitter * rand.Float64() * float64(d))
+	}
+
+	return d
+}
+
+// dialConfig returns the amqp.Config of a dial attempt with the current credentials.
+// The TLS config is cloned because amqp.DialConfig sets its ServerName from the url.
+func (c *Dialer) dialConfig() (amqp.Config, error) {
+	cfg := c.amqpConfig
+
+	if c.tlsConfig != nil {
+		cfg.TLSClientConfig = c.tlsConfig.Clone()
+	}
+
+	if c.credentials != nil {
+		username, password, err := c.credentials(c.ctx)
+		if err != nil {
+			return amqp.Config{}, fmt.Errorf("credentials: %w", err)
+		}
+
+		cfg.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: username, Password: password}}
+	}
+
+	return cfg, nil
+}
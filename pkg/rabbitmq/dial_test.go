@@ -0,0 +1,102 @@
+package rabbitmq_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/amqptest"
+)
+
+func TestBackoff_RejectsInvalidPeriods(t *testing.T) {
+	for _, tc := range []struct {
+		initial, max time.Duration
+		jitter       float64
+	}{
+		{0, time.Second, 0},
+		{time.Second, time.Millisecond, 0},
+		{time.Second, time.Minute, -0.1},
+		{time.Second, time.Minute, 1.1},
+	} {
+		if _, err := rabbitmq.Backoff(tc.initial, tc.max, tc.jitter); err == nil {
+			t.Fatalf("expected an error for %+v", tc)
+		}
+
+		// The option is validated by NewDialer.
+		if _, err := rabbitmq.NewDialer(rabbitmq.WithURL("amqp://fake"), rabbitmq.WithBackoff(tc.initial, tc.max, tc.jitter)); err == nil {
+			t.Fatalf("expected a dialer error for %+v", tc)
+		}
+	}
+}
+
+func TestBackoff_DoublesUpToMax(t *testing.T) {
+	next, err := rabbitmq.Backoff(100*time.Millisecond, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for retryCount, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if got := next(retryCount); got != want*time.Millisecond {
+			t.Fatalf("period %d is %s, want %s", retryCount, got, want*time.Millisecond)
+		}
+	}
+
+	// A large retry count stays capped.
+	if got := next(1000); got != time.Second {
+		t.Fatalf("period 1000 is %s, want 1s", got)
+	}
+}
+
+func TestBackoff_JitterReducesPeriodsWithinBounds(t *testing.T) {
+	next, err := rabbitmq.Backoff(100*time.Millisecond, time.Second, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spread := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		for retryCount, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+			got := next(retryCount)
+			max *= time.Millisecond
+			if got < max/2 || got > max {
+				t.Fatalf("period %d is %s, want between %s and %s", retryCount, got, max/2, max)
+			}
+			if retryCount == 0 {
+				spread[got] = true
+			}
+		}
+	}
+
+	if len(spread) < 2 {
+		t.Fatal("jitter did not spread the periods")
+	}
+}
+
+func TestDialer_RetriesWithBackoff(t *testing.T) {
+	b := amqptest.NewBroker()
+	b.SetDialError(errors.New("connection refused"))
+
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(amqptest.DialerInitFunc),
+		rabbitmq.WithBackoff(time.Millisecond, 10*time.Millisecond, 0.2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	b.SetDialError(nil)
+
+	timeout := time.After(time.Second)
+	for b.Connections() == 0 {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("dialer did not reconnect")
+		}
+	}
+}
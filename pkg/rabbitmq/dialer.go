@@ -2,6 +2,7 @@ package rabbitmq
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/ereb-or-od/kenobi/pkg/logging"
 	logger "github.com/ereb-or-od/kenobi/pkg/logging/interfaces"
@@ -25,6 +26,9 @@ type Unready struct {
 	Err error
 }
 
+// errNoChannelOpener tells that channels could not be opened on a connection dialed by WithAMQPDial without WithInitFunc.
+var errNoChannelOpener = errors.New("can't open channels, see WithInitFunc")
+
 // Option could be used to configure Dialer
 type Option func(c *Dialer)
 
@@ -34,6 +38,15 @@ type AMQPConnection interface {
 	Close() error
 }
 
+// AMQPChannel is the part of streadway's *amqp.Channel used to declare queues and topologies.
+type AMQPChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Close() error
+}
+
 // Connection provides access to streadway's *amqp.Connection as well as notification channels
 // A notification indicates that something wrong has happened to the connection.
 // The client should get a fresh connection from Dialer.
@@ -56,10 +69,18 @@ type config struct {
 	amqpUrls   []string
 	amqpDial   func(url string, c amqp.Config) (AMQPConnection, error)
 	amqpConfig amqp.Config
+	initFunc   func(conn AMQPConnection) (AMQPChannel, error)
 
 	logger      logger.Logger
 	retryPeriod time.Duration
 	ctx         context.Context
+	topologies  []Topology
+
+	nextRetryPeriod func(retryCount int) time.Duration
+	backoff         *backoff
+	dialTimeout     time.Duration
+	tlsConfig       *tls.Config
+	credentials     CredentialsProvider
 }
 
 // Dialer is responsible for keeping the connection up.
@@ -79,6 +100,8 @@ type Dialer struct {
 	internalStateChan chan State
 
 	closedCh chan struct{}
+
+	retryCounter int
 }
 
 // Dial returns established connection or an error.
@@ -153,6 +176,27 @@ func NewDialer(opts ...Option) (*Dialer, error) {
 			return nil, fmt.Errorf("retryPeriod must be greater then zero")
 		}
 
+		if c.backoff != nil {
+			if err := c.backoff.validate(); err != nil {
+				return nil, err
+			}
+			c.nextRetryPeriod = c.backoff.next
+		}
+
+		if c.nextRetryPeriod == nil {
+			c.nextRetryPeriod = func(_ int) time.Duration {
+				return c.retryPeriod
+			}
+		}
+
+		if c.dialTimeout < 0 {
+			return nil, fmt.Errorf("dial timeout must be not negative")
+		}
+
+		if c.dialTimeout > 0 {
+			c.amqpConfig.Dial = amqp.DefaultDial(c.dialTimeout)
+		}
+
 		go c.connectState()
 
 		return c, nil
@@ -176,6 +220,15 @@ func WithAMQPDial(dial func(url string, c amqp.Config) (AMQPConnection, error))
 	}
 }
 
+// WithInitFunc configure how channels are opened on the dialed connections, it must be set along with WithAMQPDial
+// when the connections are not streadway's *amqp.Connection. The consumers and publishers created by Dialer
+// open their channels with it too, provided the channel it returns implements their AMQPChannel.
+func WithInitFunc(f func(conn AMQPConnection) (AMQPChannel, error)) Option {
+	return func(c *Dialer) {
+		c.initFunc = f
+	}
+}
+
 // WithLogger configure the logger used by Dialer
 func WithLogger(l logger.Logger) Option {
 	return func(c *Dialer) {
@@ -198,6 +251,47 @@ func WithRetryPeriod(dur time.Duration) Option {
 	}
 }
 
+// WithRetryPeriodFunc configure how much time to wait before next dial attempt depending on the number of failed attempts
+// since the last established connection. It takes precedence over WithRetryPeriod.
+func WithRetryPeriodFunc(durFunc func(retryCount int) time.Duration) Option {
+	return func(c *Dialer) {
+		c.nextRetryPeriod = durFunc
+		c.backoff = nil
+	}
+}
+
+// WithBackoff configure an exponential backoff between dial attempts, see Backoff.
+func WithBackoff(initial, max time.Duration, jitter float64) Option {
+	return func(c *Dialer) {
+		c.backoff = &backoff{initial: initial, max: max, jitter: jitter}
+		c.nextRetryPeriod = nil
+	}
+}
+
+// WithDialTimeout configure the timeout of each dial attempt, including the TLS and AMQP handshakes. Default: 30sec.
+// It is not applied to a dial function set by WithAMQPDial.
+func WithDialTimeout(dur time.Duration) Option {
+	return func(c *Dialer) {
+		c.dialTimeout = dur
+	}
+}
+
+// WithTLS configure the TLS config used to dial amqps:// urls, like amqp.DialTLS.
+// Client certificates are set in its Certificates, see TLSConfig.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Dialer) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithCredentials configure a provider called before every dial attempt,
+// so rotated credentials are used on the next reconnection. The credentials of the urls are ignored.
+func WithCredentials(provider CredentialsProvider) Option {
+	return func(c *Dialer) {
+		c.credentials = provider
+	}
+}
+
 // WithConnectionProperties configure connection properties set on dial.
 func WithConnectionProperties(props amqp.Table) Option {
 	return func(c *Dialer) {
@@ -255,7 +349,32 @@ func (c *Dialer) Close() {
 
 // Connection returns streadway's *amqp.Connection.
 // The client should subscribe on Dialer.NotifyReady(), Dialer.NotifyUnready() events in order to know when the connection is lost.
+// It fails when the connections are dialed by WithAMQPDial as another type, Dialer.Channel could be used instead.
 func (c *Dialer) Connection(ctx context.Context) (*amqp.Connection, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	amqpConn, ok := conn.amqpConn.(*amqp.Connection)
+	if !ok {
+		return nil, fmt.Errorf("connection %T is not *amqp.Connection", conn.amqpConn)
+	}
+
+	return amqpConn, nil
+}
+
+// Channel opens a channel on the connection, see WithInitFunc. The client must close it.
+func (c *Dialer) Channel(ctx context.Context) (AMQPChannel, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.channel(conn.amqpConn)
+}
+
+func (c *Dialer) connection(ctx context.Context) (*Connection, error) {
 	select {
 	case <-c.ctx.Done():
 		return nil, fmt.Errorf("connection closed")
@@ -266,8 +385,26 @@ func (c *Dialer) Connection(ctx context.Context) (*amqp.Connection, error) {
 			return nil, fmt.Errorf("connection closed")
 		}
 
-		return conn.AMQPConnection(), nil
+		return conn, nil
+	}
+}
+
+func (c *Dialer) channel(conn AMQPConnection) (AMQPChannel, error) {
+	if c.initFunc != nil {
+		return c.initFunc(conn)
+	}
+
+	amqpConn, ok := conn.(*amqp.Connection)
+	if !ok {
+		return nil, fmt.Errorf("connection %T: %w", conn, errNoChannelOpener)
+	}
+
+	ch, err := amqpConn.Channel()
+	if err != nil {
+		return nil, err
 	}
+
+	return ch, nil
 }
 
 // Consumer returns a consumer that support reconnection feature.
@@ -277,6 +414,10 @@ func (c *Dialer) Consumer(opts ...consumer.Option) (*consumer.Consumer, error) {
 		consumer.WithContext(c.ctx),
 	}, opts...)
 
+	if c.initFunc != nil {
+		opts = append([]consumer.Option{consumer.WithInitFunc(c.ConsumerInitFunc(nil))}, opts...)
+	}
+
 	return NewConsumer(c.ConnectionCh(), opts...)
 }
 
@@ -287,9 +428,86 @@ func (c *Dialer) Publisher(opts ...publisher.Option) (*publisher.Publisher, erro
 		publisher.WithContext(c.ctx),
 	}, opts...)
 
+	if c.initFunc != nil {
+		opts = append([]publisher.Option{publisher.WithInitFunc(c.PublisherInitFunc(nil))}, opts...)
+	}
+
 	return NewPublisher(c.ConnectionCh(), opts...)
 }
 
+// PublisherPool returns a pool of size publishers sharing the connection, each one with its own channel.
+func (c *Dialer) PublisherPool(size int, opts ...publisher.Option) (*publisher.Pool, error) {
+	opts = append([]publisher.Option{
+		publisher.WithLogger(c.logger),
+		publisher.WithContext(c.ctx),
+	}, opts...)
+
+	if c.initFunc != nil {
+		opts = append([]publisher.Option{publisher.WithInitFunc(c.PublisherInitFunc(nil))}, opts...)
+	}
+
+	return NewPublisherPool(c.ConnectionCh(), size, opts...)
+}
+
+// ConsumerInitFunc returns a consumer init func opening the channels like Dialer.Channel does.
+// declare, if not nil, is run on every new channel, e.g. to declare the exchange the consumer binds to.
+func (c *Dialer) ConsumerInitFunc(declare func(ch AMQPChannel) error) func(conn consumer.AMQPConnection) (consumer.AMQPChannel, error) {
+	return func(conn consumer.AMQPConnection) (consumer.AMQPChannel, error) {
+		ch, err := c.declaredChannel(conn, declare)
+		if err != nil {
+			return nil, err
+		}
+
+		consumerCh, ok := ch.(consumer.AMQPChannel)
+		if !ok {
+			_ = ch.Close()
+			return nil, fmt.Errorf("channel %T can't consume", ch)
+		}
+
+		return consumerCh, nil
+	}
+}
+
+// PublisherInitFunc returns a publisher init func opening the channels like Dialer.Channel does.
+// declare, if not nil, is run on every new channel, e.g. to declare the exchange the publisher publishes to.
+func (c *Dialer) PublisherInitFunc(declare func(ch AMQPChannel) error) func(conn publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+	return func(conn publisher.AMQPConnection) (publisher.AMQPChannel, error) {
+		ch, err := c.declaredChannel(conn, declare)
+		if err != nil {
+			return nil, err
+		}
+
+		publisherCh, ok := ch.(publisher.AMQPChannel)
+		if !ok {
+			_ = ch.Close()
+			return nil, fmt.Errorf("channel %T can't publish", ch)
+		}
+
+		return publisherCh, nil
+	}
+}
+
+func (c *Dialer) declaredChannel(conn interface{}, declare func(ch AMQPChannel) error) (AMQPChannel, error) {
+	amqpConn, ok := conn.(AMQPConnection)
+	if !ok {
+		return nil, fmt.Errorf("connection %T is not AMQPConnection", conn)
+	}
+
+	ch, err := c.channel(amqpConn)
+	if err != nil {
+		return nil, err
+	}
+
+	if declare != nil {
+		if err = declare(ch); err != nil {
+			_ = ch.Close()
+			return nil, err
+		}
+	}
+
+	return ch, nil
+}
+
 // connectState is a starting point.
 // It chooses URL and dials the server.
 // Once connection is established it pass control to Dialer.connectedState()
@@ -321,7 +539,13 @@ func (c *Dialer) connectState() {
 
 		go func() {
 			c.logger.Debug("[DEBUG] dialing")
-			if conn, err := c.amqpDial(url, c.amqpConfig); err != nil {
+			amqpConfig, err := c.dialConfig()
+			if err != nil {
+				errorCh <- err
+				return
+			}
+
+			if conn, err := c.amqpDial(url, amqpConfig); err != nil {
 				errorCh <- err
 			} else {
 				connCh <- conn
@@ -341,6 +565,26 @@ func (c *Dialer) connectState() {
 				default:
 				}
 
+				if err := c.declareTopologies(conn); err != nil {
+					c.logger.Error("[ERROR] declare topology", err)
+					c.closeConn(conn)
+
+					// Dialing again would not help, the Dialer is misconfigured.
+					if errors.Is(err, errNoChannelOpener) {
+						c.notifyUnready(err)
+						return
+					}
+
+					if retryErr := c.waitRetry(err); retryErr != nil {
+						state = State{Unready: &Unready{Err: retryErr}}
+						break loop2
+					}
+
+					return
+				}
+
+				c.retryCounter = 0
+
 				if err := c.connectedState(conn); err != nil {
 					c.logger.Error("[ERROR] connection unready: %s", err)
 					state = c.notifyUnready(err)
@@ -420,7 +664,8 @@ func (c *Dialer) notifyReady() State {
 }
 
 func (c *Dialer) waitRetry(err error) error {
-	timer := time.NewTimer(c.retryPeriod)
+	timer := time.NewTimer(c.nextRetryPeriod(c.retryCounter))
+	c.retryCounter++
 	defer func() {
 		timer.Stop()
 		select {
@@ -1,6 +1,10 @@
 package rabbitmq
 
-import "github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+import (
+	"fmt"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+)
 
 func NewPublisher(
 	connCh <-chan *Connection,
@@ -35,7 +39,7 @@ func proxyPublisherConn(
 				}
 
 				publisherConn := publisher.NewConnection(
-					conn.AMQPConnection(),
+					conn.amqpConn,
 					conn.NotifyLost(),
 				)
 
@@ -52,3 +56,29 @@ func proxyPublisherConn(
 		}
 	}()
 }
+
+// NewPublisherPool returns a publisher.Pool of size publishers, each one with its own channel on the connections of connCh.
+func NewPublisherPool(
+	connCh <-chan *Connection,
+	size int,
+	opts ...publisher.Option,
+) (*publisher.Pool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("pool size must be greater than zero")
+	}
+
+	publishers := make([]*publisher.Publisher, 0, size)
+	for i := 0; i < size; i++ {
+		p, err := NewPublisher(connCh, opts...)
+		if err != nil {
+			for _, created := range publishers {
+				created.Close()
+			}
+			return nil, err
+		}
+
+		publishers = append(publishers, p)
+	}
+
+	return publisher.NewPool(publishers...)
+}
@@ -0,0 +1,140 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// ErrReturned is returned for a mandatory message the broker could not route to any queue.
+var ErrReturned = errors.New("publisher: message returned")
+
+// BatchResult is the outcome of a message published by PublishBatch.
+type BatchResult struct {
+	// DeliveryTag is the publishing sequence number on the channel, zero when the message was not confirmed.
+	DeliveryTag uint64
+	// Err is nil when the broker confirmed the message and did not return it.
+	Err error
+	// Returned is the basic.return of an unroutable mandatory message.
+	Returned *amqp.Return
+}
+
+// batchEntry is filled by handleConfirmations while PublishBatch waits, it is guarded by Publisher.batchMu.
+type batchEntry struct {
+	deliveryTag uint64
+	returned    *amqp.Return
+}
+
+// PublishBatch publishes the messages one after the other without waiting for their confirmations,
+// then waits for all of them. The results are in the same order as the messages.
+// It requires WithConfirmation, the buffer should be greater than or equal to the batch size.
+// Returned mandatory messages are matched by MessageId, one is generated when it is empty,
+// so the ids of the mandatory messages in flight must be unique.
+// The Context of the messages defaults to ctx, their ResultCh is not used.
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []Message) ([]BatchResult, error) {
+	if !p.confirmation {
+		return nil, fmt.Errorf("publish batch requires confirmation mode")
+	}
+
+	msgs = append([]Message(nil), msgs...)
+	results := make([]BatchResult, len(msgs))
+	entries := make([]batchEntry, len(msgs))
+	resultChs := make([]<-chan error, len(msgs))
+
+	defer func() {
+		for i := range msgs {
+			p.batchResults.Delete(msgs[i].ResultCh)
+			if msgs[i].Mandatory {
+				p.returns.Delete(msgs[i].Publishing.MessageId)
+			}
+		}
+	}()
+
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Context == nil {
+			msg.Context = ctx
+		}
+		msg.ResultCh = make(chan error, 1)
+
+		if msg.Mandatory {
+			if msg.Publishing.MessageId == "" {
+				msg.Publishing.MessageId = uuid.NewString()
+			}
+			p.returns.Store(msg.Publishing.MessageId, &entries[i])
+		}
+		p.batchResults.Store(msg.ResultCh, &entries[i])
+
+		resultChs[i] = p.Go(*msg)
+	}
+
+	for i, resultCh := range resultChs {
+		var err error
+		select {
+		case err = <-resultCh:
+		case <-ctx.Done():
+			err = fmt.Errorf("message: %v", ctx.Err())
+		}
+
+		results[i].Err = err
+	}
+
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+
+	for i := range results {
+		results[i].DeliveryTag = entries[i].deliveryTag
+		results[i].Returned = entries[i].returned
+
+		if r := results[i].Returned; r != nil && results[i].Err == nil {
+			results[i].Err = fmt.Errorf("%w: %d %s", ErrReturned, r.ReplyCode, r.ReplyText)
+		}
+	}
+
+	return results, nil
+}
+
+// recordConfirmation saves the delivery tag of a message published by PublishBatch before its result is sent.
+func (p *Publisher) recordConfirmation(resultCh chan error, c amqp.Confirmation) {
+	if e, ok := p.batchResults.Load(resultCh); ok {
+		p.batchMu.Lock()
+		e.(*batchEntry).deliveryTag = c.DeliveryTag
+		p.batchMu.Unlock()
+	}
+}
+
+// recordReturn saves the basic.return of a mandatory message published by PublishBatch.
+func (p *Publisher) recordReturn(ret amqp.Return) {
+	e, ok := p.returns.Load(ret.MessageId)
+	if !ok {
+		p.logger.Warn("[WARN] publisher: message returned", map[string]interface{}{
+			"exchange":   ret.Exchange,
+			"routingKey": ret.RoutingKey,
+			"replyCode":  ret.ReplyCode,
+			"replyText":  ret.ReplyText,
+		})
+		return
+	}
+
+	p.batchMu.Lock()
+	e.(*batchEntry).returned = &ret
+	p.batchMu.Unlock()
+}
+
+// drainReturns records the returns already received.
+func (p *Publisher) drainReturns(returnCh chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returnCh:
+			if !ok {
+				return
+			}
+			p.recordReturn(ret)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/metrics"
+)
+
+// ErrBufferFull is returned by BufferedPublisher.Publish when the outbound buffer can't accept more messages.
+var ErrBufferFull = errors.New("publisher: outbound buffer full")
+
+type (
+	// BufferedOption could be used to configure BufferedPublisher
+	BufferedOption func(b *BufferedPublisher)
+
+	// BufferedPublisher accepts messages while the Publisher is unready and publishes them in order once it is ready.
+	// Messages are kept in a bounded memory buffer and, when a spill directory is set, written to disk once it is full.
+	// Spilled messages survive a restart. Publish returns once the message is buffered,
+	// so the Context and ResultCh of the messages are not used.
+	BufferedPublisher struct {
+		publisher *Publisher
+
+		size          int
+		dir           string
+		spillLimit    int
+		retryPeriod   time.Duration
+		metricsPeriod time.Duration
+		name          string
+
+		mu     sync.Mutex
+		memory []bufferedMessage
+		spill  *spill
+
+		wakeCh     chan struct{}
+		ctx        context.Context
+		cancelFunc context.CancelFunc
+		doneCh     chan struct{}
+	}
+)
+
+// NewBufferedPublisher returns BufferedPublisher or a configuration error.
+func NewBufferedPublisher(p *Publisher, opts ...BufferedOption) (*BufferedPublisher, error) {
+	b := &BufferedPublisher{
+		publisher:     p,
+		size:          1000,
+		retryPeriod:   time.Second,
+		metricsPeriod: time.Second * 10,
+		wakeCh:        make(chan struct{}, 1),
+		doneCh:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.publisher == nil {
+		return nil, fmt.Errorf("publisher must be not nil")
+	}
+
+	if b.size < 1 {
+		return nil, fmt.Errorf("buffer size must be greater than zero")
+	}
+
+	if b.retryPeriod <= 0 || b.metricsPeriod <= 0 {
+		return nil, fmt.Errorf("periods must be greater than zero")
+	}
+
+	if b.dir != "" {
+		s, err := openSpill(b.dir)
+		if err != nil {
+			return nil, err
+		}
+		b.spill = s
+	}
+
+	b.ctx, b.cancelFunc = context.WithCancel(context.Background())
+
+	go b.run()
+
+	return b, nil
+}
+
+// WithBufferSize configure the number of messages kept in memory. Default: 1000.
+func WithBufferSize(size int) BufferedOption {
+	return func(b *BufferedPublisher) {
+		b.size = size
+	}
+}
+
+// WithSpillDir configure the directory where the messages are written once the memory buffer is full.
+func WithSpillDir(dir string) BufferedOption {
+	return func(b *BufferedPublisher) {
+		b.dir = dir
+	}
+}
+
+// WithSpillLimit configure the max number of messages written to disk. Default: 0, unlimited.
+func WithSpillLimit(limit int) BufferedOption {
+	return func(b *BufferedPublisher) {
+		b.spillLimit = limit
+	}
+}
+
+// WithBufferRetryPeriod configure how much time to wait before publishing again after a failure. Default: 1sec.
+func WithBufferRetryPeriod(dur time.Duration) BufferedOption {
+	return func(b *BufferedPublisher) {
+		b.retryPeriod = dur
+	}
+}
+
+// WithBufferMetrics configure the name used as label of the buffer metrics and how often they are emitted. Default: 10sec.
+func WithBufferMetrics(name string, period time.Duration) BufferedOption {
+	return func(b *BufferedPublisher) {
+		b.name = name
+		b.metricsPeriod = period
+	}
+}
+
+// Publish adds the message to the outbound buffer.
+func (b *BufferedPublisher) Publish(msg Message) error {
+	m := bufferedMessage{
+		Exchange:   msg.Exchange,
+		Key:        msg.Key,
+		Mandatory:  msg.Mandatory,
+		Immediate:  msg.Immediate,
+		Publishing: msg.Publishing,
+		EnqueuedAt: time.Now(),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.ctx.Done():
+		return fmt.Errorf("publisher stopped")
+	default:
+	}
+
+	// Once a message is on disk the next ones follow it to keep the order.
+	if len(b.memory) < b.size && (b.spill == nil || b.spill.count == 0) {
+		b.memory = append(b.memory, m)
+	} else if err := b.spillMessage(m); err != nil {
+		return err
+	}
+
+	select {
+	case b.wakeCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Len returns the number of buffered messages.
+func (b *BufferedPublisher) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.memory)
+	if b.spill != nil {
+		n += b.spill.count
+	}
+	return n
+}
+
+// Close stops publishing. The messages in memory are written to disk when a spill directory is set.
+func (b *BufferedPublisher) Close() {
+	b.cancelFunc()
+	<-b.doneCh
+}
+
+// NotifyClosed notifies when BufferedPublisher is closed.
+func (b *BufferedPublisher) NotifyClosed() <-chan struct{} {
+	return b.doneCh
+}
+
+// spillMessage writes the message to disk. The lock must be held.
+func (b *BufferedPublisher) spillMessage(m bufferedMessage) error {
+	if b.spill == nil || (b.spillLimit > 0 && b.spill.count >= b.spillLimit) {
+		return ErrBufferFull
+	}
+
+	return b.spill.push(m)
+}
+
+func (b *BufferedPublisher) run() {
+	defer close(b.doneCh)
+	defer b.shutdown()
+
+	stateCh := make(chan State, 1)
+	go b.publisher.Notify(stateCh)
+
+	ticker := time.NewTicker(b.metricsPeriod)
+	defer ticker.Stop()
+
+	ready := false
+	var retryCh <-chan time.Time
+
+	for {
+		if ready && retryCh == nil {
+			if err := b.publishNext(); err != nil {
+				b.publisher.logger.Error("[ERROR] buffered publisher: publish", err)
+				retryCh = time.After(b.retryPeriod)
+			} else if b.Len() > 0 {
+				continue
+			}
+		}
+
+		select {
+		case state := <-stateCh:
+			ready = state.Ready != nil
+			retryCh = nil
+		case <-b.wakeCh:
+		case <-retryCh:
+			retryCh = nil
+		case <-ticker.C:
+			b.reportMetrics()
+		case <-b.publisher.NotifyClosed():
+			return
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// publishNext publishes the oldest buffered message and removes it once published.
+func (b *BufferedPublisher) publishNext() error {
+	b.mu.Lock()
+	var (
+		m         bufferedMessage
+		ok        bool
+		fromSpill bool
+		err       error
+	)
+	if len(b.memory) > 0 {
+		m, ok = b.memory[0], true
+	} else if b.spill != nil {
+		m, ok, err = b.spill.peek()
+		fromSpill = true
+	}
+	b.mu.Unlock()
+
+	if err != nil || !ok {
+		return err
+	}
+
+	if err = b.publisher.Publish(Message{
+		Context:      b.ctx,
+		Exchange:     m.Exchange,
+		Key:          m.Key,
+		Mandatory:    m.Mandatory,
+		Immediate:    m.Immediate,
+		ErrOnUnready: true,
+		Publishing:   m.Publishing,
+	}); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if fromSpill {
+		return b.spill.pop()
+	}
+
+	b.memory[0] = bufferedMessage{}
+	b.memory = b.memory[1:]
+
+	return nil
+}
+
+func (b *BufferedPublisher) reportMetrics() {
+	b.mu.Lock()
+	depth := len(b.memory)
+	var oldest time.Time
+	if len(b.memory) > 0 {
+		oldest = b.memory[0].EnqueuedAt
+	}
+	if b.spill != nil {
+		depth += b.spill.count
+		if oldest.IsZero() {
+			if m, ok, err := b.spill.peek(); err == nil && ok {
+				oldest = m.EnqueuedAt
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	var age time.Duration
+	if !oldest.IsZero() {
+		age = time.Since(oldest)
+	}
+
+	labels := []metrics.Label{{Name: "publisher", Value: b.name}}
+	metrics.SetGaugeWithLabels([]string{"rabbitmq", "publisher", "buffer", "depth"}, float32(depth), labels)
+	metrics.SetGaugeWithLabels([]string{"rabbitmq", "publisher", "buffer", "oldest_age"}, float32(age.Seconds()), labels)
+}
+
+// shutdown writes the messages in memory to disk before the spill file is closed.
+func (b *BufferedPublisher) shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spill == nil {
+		if len(b.memory) > 0 {
+			b.publisher.logger.Warn("[WARN] buffered publisher: messages lost on close", map[string]interface{}{"count": len(b.memory)})
+		}
+		return
+	}
+
+	if err := b.spill.prepend(b.memory); err != nil {
+		b.publisher.logger.Error("[ERROR] buffered publisher: spill on close", err, map[string]interface{}{"count": len(b.memory)})
+	}
+	b.memory = nil
+
+	if err := b.spill.close(); err != nil {
+		b.publisher.logger.Error("[ERROR] buffered publisher: close spill", err)
+	}
+}
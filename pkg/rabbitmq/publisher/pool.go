@@ -0,0 +1,139 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Pool spreads the messages over several publishers, each one holding its own channel,
+// so the throughput is not capped by the flow control and the confirmations of a single channel.
+// Each publisher pauses on channel flow control and recreates its channel independently,
+// the messages go round-robin to the ready ones. The order of the messages is not kept across channels.
+type Pool struct {
+	publishers []*Publisher
+	ready      []int32
+	next       uint32
+
+	closeCh chan struct{}
+}
+
+// NewPool returns Pool or a configuration error. The publishers are closed with the Pool.
+func NewPool(publishers ...*Publisher) (*Pool, error) {
+	if len(publishers) == 0 {
+		return nil, fmt.Errorf("publishers must be not empty")
+	}
+
+	for _, p := range publishers {
+		if p == nil {
+			return nil, fmt.Errorf("publisher must be not nil")
+		}
+	}
+
+	pool := &Pool{
+		publishers: publishers,
+		ready:      make([]int32, len(publishers)),
+		closeCh:    make(chan struct{}),
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := range publishers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pool.watch(i)
+		}(i)
+	}
+
+	go func() {
+		wg.Wait()
+		close(pool.closeCh)
+	}()
+
+	return pool, nil
+}
+
+// Size returns the number of publishers.
+func (pool *Pool) Size() int {
+	return len(pool.publishers)
+}
+
+// Ready returns the number of publishers ready to publish.
+func (pool *Pool) Ready() int {
+	n := 0
+	for i := range pool.ready {
+		if atomic.LoadInt32(&pool.ready[i]) == 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Publish publishes the message with the next ready publisher, see Publisher.Publish.
+func (pool *Pool) Publish(msg Message) error {
+	return <-pool.Go(msg)
+}
+
+// Go publishes the message with the next ready publisher, see Publisher.Go.
+// When none is ready the message waits for the next publisher, or fails with ErrOnUnready.
+func (pool *Pool) Go(msg Message) <-chan error {
+	return pool.pick().Go(msg)
+}
+
+// PublishBatch publishes all the messages with the next ready publisher, see Publisher.PublishBatch.
+// A batch is never spread over several publishers, so it gets the flow control and the confirmations of a single channel;
+// call it several times with smaller batches to use the whole pool.
+func (pool *Pool) PublishBatch(ctx context.Context, msgs []Message) ([]BatchResult, error) {
+	return pool.pick().PublishBatch(ctx, msgs)
+}
+
+// Close closes all the publishers.
+func (pool *Pool) Close() {
+	for _, p := range pool.publishers {
+		p.Close()
+	}
+}
+
+// NotifyClosed notifies when all the publishers are closed.
+func (pool *Pool) NotifyClosed() <-chan struct{} {
+	return pool.closeCh
+}
+
+func (pool *Pool) pick() *Publisher {
+	n := uint32(len(pool.publishers))
+	start := atomic.AddUint32(&pool.next, 1)
+
+	for i := uint32(0); i < n; i++ {
+		idx := (start + i) % n
+		if atomic.LoadInt32(&pool.ready[idx]) == 1 {
+			// The next pick starts after the chosen publisher, so the one following an unready one gets no extra share.
+			if i > 0 {
+				atomic.AddUint32(&pool.next, i)
+			}
+			return pool.publishers[idx]
+		}
+	}
+
+	return pool.publishers[start%n]
+}
+
+// watch keeps the readiness of a publisher up to date until it is closed.
+func (pool *Pool) watch(i int) {
+	p := pool.publishers[i]
+	defer atomic.StoreInt32(&pool.ready[i], 0)
+
+	stateCh := p.Notify(make(chan State, 1))
+	for {
+		select {
+		case state := <-stateCh:
+			if state.Ready != nil {
+				atomic.StoreInt32(&pool.ready[i], 1)
+			} else {
+				atomic.StoreInt32(&pool.ready[i], 0)
+			}
+		case <-p.NotifyClosed():
+			return
+		}
+	}
+}
@@ -32,6 +32,7 @@ type AMQPChannel interface {
 	NotifyFlow(c chan bool) chan bool
 	Close() error
 	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
 	Confirm(noWait bool) error
 }
 
@@ -57,12 +58,20 @@ type Publisher struct {
 	initFunc    func(conn AMQPConnection) (AMQPChannel, error)
 	logger      logger.Logger
 
+	// state is the last state sent to stateChs, Notify sends it to a new chan under the same lock.
 	mu       sync.Mutex
 	stateChs []chan State
+	state    State
 
 	confirmation       bool
 	confirmationBuffer uint
 
+	// batchResults and returns hold the results of the messages published by PublishBatch,
+	// by result chan and by message id. They are filled by handleConfirmations.
+	batchMu      sync.Mutex
+	batchResults sync.Map
+	returns      sync.Map
+
 	closeCh chan struct{}
 
 	publishingCh chan Message
@@ -80,6 +89,7 @@ func New(
 		publishingCh:    make(chan Message),
 		closeCh:         make(chan struct{}),
 		internalStateCh: make(chan State),
+		state:           State{Unready: &Unready{Err: amqp.ErrClosed}},
 	}
 
 	for _, opt := range opts {
@@ -162,21 +172,32 @@ func WithConfirmation(buffer uint) Option {
 	}
 }
 
+// Confirming reports whether the publisher was created WithConfirmation.
+func (p *Publisher) Confirming() bool {
+	return p.confirmation
+}
+
 func (p *Publisher) Notify(stateCh chan State) <-chan State {
 	if cap(stateCh) == 0 {
 		panic("state chan is unbuffered")
 	}
 
 	select {
-	case state := <-p.internalStateCh:
-		stateCh <- state
 	case <-p.NotifyClosed():
 		return stateCh
+	default:
 	}
 
+	// The chan is registered with the current state at once, so no transition is missed in between.
 	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case stateCh <- p.state:
+	case <-stateCh:
+		stateCh <- p.state
+	}
 	p.stateChs = append(p.stateChs, stateCh)
-	p.mu.Unlock()
 
 	return stateCh
 }
@@ -293,10 +314,11 @@ func (p *Publisher) channelState(conn AMQPConnection, connCloseCh <-chan struct{
 			}
 
 			confirmationCh := ch.NotifyPublish(make(chan amqp.Confirmation, p.confirmationBuffer))
+			returnCh := ch.NotifyReturn(make(chan amqp.Return, p.confirmationBuffer))
 
 			resultChCh = make(chan chan error, p.confirmationBuffer)
 
-			go p.handleConfirmations(resultChCh, confirmationCh, confirmationCloseCh, confirmationDoneCh)
+			go p.handleConfirmations(resultChCh, confirmationCh, returnCh, confirmationCloseCh, confirmationDoneCh)
 		} else {
 			close(confirmationDoneCh)
 		}
@@ -321,6 +343,7 @@ func (p *Publisher) channelState(conn AMQPConnection, connCloseCh <-chan struct{
 func (p *Publisher) handleConfirmations(
 	resultChCh chan chan error,
 	confirmationCh chan amqp.Confirmation,
+	returnCh chan amqp.Return,
 	confirmationCloseCh,
 	confirmationDoneCh chan struct{},
 ) {
@@ -335,6 +358,8 @@ func (p *Publisher) handleConfirmations(
 
 		p.logger.Debug("[DEBUG] handle confirmation ready")
 	case <-confirmationCloseCh:
+		// Messages could be published before the handler started.
+		p.failUnconfirmed(resultChCh)
 		return
 	}
 
@@ -349,7 +374,12 @@ loop:
 				break loop
 			}
 
+			// The broker sends basic.return before the confirmation of an unroutable message,
+			// so it is already in returnCh and recorded before the result is sent.
+			p.drainReturns(returnCh)
+
 			resultCh := <-resultChCh
+			p.recordConfirmation(resultCh, c)
 			if c.Ack {
 				resultCh <- nil
 			} else {
@@ -357,16 +387,27 @@ loop:
 			}
 
 			continue
+		case r, ok := <-returnCh:
+			if !ok {
+				returnCh = nil
+				continue
+			}
+
+			p.recordReturn(r)
 		case <-confirmationCloseCh:
 			break loop
 		}
 	}
 	<-confirmationCloseCh
+	p.failUnconfirmed(resultChCh)
+}
+
+// failUnconfirmed fails the messages still waiting for a confirmation of the closed channel.
+func (p *Publisher) failUnconfirmed(resultChCh chan chan error) {
 	for {
 		select {
 		case resultCh := <-resultChCh:
 			resultCh <- amqp.ErrClosed
-			continue
 		default:
 			return
 		}
@@ -432,6 +473,7 @@ func (p *Publisher) publish(ch AMQPChannel, msg Message, resultChCh chan chan er
 	select {
 	case <-msg.Context.Done():
 		msg.ResultCh <- fmt.Errorf("message: %v", msg.Context.Err())
+		return
 	default:
 	}
 
@@ -487,6 +529,7 @@ func (p *Publisher) notifyUnready(err error) State {
 	state := State{Unready: &Unready{Err: err}}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.state = state
 	for _, stateCh := range p.stateChs {
 		select {
 		case stateCh <- state:
@@ -501,6 +544,7 @@ func (p *Publisher) notifyReady() State {
 	state := State{Ready: &Ready{}}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.state = state
 	for _, stateCh := range p.stateChs {
 		select {
 		case stateCh <- state:
@@ -0,0 +1,306 @@
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	spillFile       = "outbound.spill"
+	spillOffsetFile = "outbound.offset"
+)
+
+func init() {
+	// Header values are stored in interfaces, gob needs their concrete types.
+	gob.Register(amqp.Table{})
+	gob.Register(amqp.Decimal{})
+	gob.Register(time.Time{})
+	gob.Register([]interface{}{})
+}
+
+type (
+	// bufferedMessage is a message waiting in the outbound buffer.
+	bufferedMessage struct {
+		Exchange   string
+		Key        string
+		Mandatory  bool
+		Immediate  bool
+		Publishing amqp.Publishing
+		EnqueuedAt time.Time
+	}
+
+	// spill is an append-only file of buffered messages. The read position is saved in a separate file,
+	// so the messages not published are replayed after a restart. The file is truncated once fully read.
+	// It is opened once for appending and once for reading the oldest message.
+	spill struct {
+		path       string
+		offsetPath string
+		file       *os.File
+		reader     *os.File
+
+		readPos int64
+		count   int
+
+		head     *bufferedMessage
+		headSize int64
+	}
+)
+
+func openSpill(dir string) (*spill, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &spill{
+		path:       filepath.Join(dir, spillFile),
+		offsetPath: filepath.Join(dir, spillOffsetFile),
+	}
+
+	if data, err := ioutil.ReadFile(s.offsetPath); err == nil {
+		if s.readPos, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// open opens the append and read handles of the spill file.
+func (s *spill) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(s.path)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file, s.reader = f, r
+	return nil
+}
+
+// recover counts the records after the read position and truncates an incomplete record at the end.
+func (s *spill) recover() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.readPos = 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var pos int64
+	for {
+		data, err := readSpillRecord(r)
+		if err != nil {
+			break
+		}
+		if pos >= s.readPos {
+			s.count++
+		}
+		pos += int64(4 + len(data))
+	}
+
+	if s.readPos > pos {
+		s.readPos = pos
+	}
+
+	return os.Truncate(s.path, pos)
+}
+
+func (s *spill) push(m bufferedMessage) error {
+	record, err := encodeSpillRecord(m)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.file.Write(record); err != nil {
+		return err
+	}
+
+	s.count++
+	return nil
+}
+
+// peek returns the oldest message of the spill without removing it.
+func (s *spill) peek() (bufferedMessage, bool, error) {
+	if s.count == 0 {
+		return bufferedMessage{}, false, nil
+	}
+
+	if s.head != nil {
+		return *s.head, true, nil
+	}
+
+	data, err := readSpillRecord(io.NewSectionReader(s.reader, s.readPos, math.MaxInt64-s.readPos))
+	if err != nil {
+		return bufferedMessage{}, false, err
+	}
+
+	var m bufferedMessage
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		return bufferedMessage{}, false, err
+	}
+
+	s.head = &m
+	s.headSize = int64(4 + len(data))
+
+	return m, true, nil
+}
+
+// pop removes the message returned by peek.
+func (s *spill) pop() error {
+	if s.head == nil {
+		if _, ok, err := s.peek(); err != nil || !ok {
+			return err
+		}
+	}
+
+	s.readPos += s.headSize
+	s.count--
+	s.head = nil
+
+	if s.count == 0 {
+		if err := s.file.Truncate(0); err != nil {
+			return err
+		}
+		s.readPos = 0
+	}
+
+	return ioutil.WriteFile(s.offsetPath, []byte(strconv.FormatInt(s.readPos, 10)), 0644)
+}
+
+// prepend writes the messages before the ones of the spill, they are older.
+func (s *spill) prepend(msgs []bufferedMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := writeSpill(tmpPath, msgs, io.NewSectionReader(s.reader, s.readPos, math.MaxInt64-s.readPos)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// The handles still refer to the replaced file, they are reopened on the new one.
+	err := s.close()
+
+	s.readPos = 0
+	s.count += len(msgs)
+	s.head = nil
+
+	if writeErr := ioutil.WriteFile(s.offsetPath, []byte("0"), 0644); writeErr != nil {
+		return writeErr
+	}
+
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+
+	return err
+}
+
+func (s *spill) close() error {
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+
+	if s.reader != nil {
+		if readerErr := s.reader.Close(); err == nil {
+			err = readerErr
+		}
+		s.reader = nil
+	}
+
+	return err
+}
+
+// writeSpill writes the messages followed by the remaining records to a new file.
+func writeSpill(path string, msgs []bufferedMessage, remaining io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range msgs {
+		record, err := encodeSpillRecord(m)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err = f.Write(record); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if _, err = io.Copy(f, remaining); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func encodeSpillRecord(m bufferedMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, 4+buf.Len())
+	binary.BigEndian.PutUint32(record, uint32(buf.Len()))
+	copy(record[4:], buf.Bytes())
+
+	return record, nil
+}
+
+func readSpillRecord(r io.Reader) ([]byte, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(size))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,183 @@
+package publisher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func spilled(id string) bufferedMessage {
+	return bufferedMessage{Key: "orders", Publishing: amqp.Publishing{MessageId: id}}
+}
+
+func openTestSpill(t *testing.T, dir string) *spill {
+	t.Helper()
+
+	s, err := openSpill(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+
+	return s
+}
+
+func pushAll(t *testing.T, s *spill, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		if err := s.push(spilled(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// popAll reads the spill until it is empty and returns the message ids.
+func popAll(t *testing.T, s *spill) []string {
+	t.Helper()
+
+	var ids []string
+	for {
+		m, ok, err := s.peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return ids
+		}
+		ids = append(ids, m.Publishing.MessageId)
+
+		if err = s.pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertIds(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got messages %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got messages %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSpill_ReplaysUnpublishedMessagesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpill(t, dir)
+	pushAll(t, s, "1", "2", "3")
+	if err := s.pop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The read position was saved by pop.
+	s = openTestSpill(t, dir)
+	if s.count != 2 {
+		t.Fatalf("%d messages recovered, want 2", s.count)
+	}
+	assertIds(t, popAll(t, s), "2", "3")
+
+	// The file was truncated once fully read, the next messages are appended at its start.
+	pushAll(t, s, "4")
+	if info, err := os.Stat(filepath.Join(dir, spillFile)); err != nil || info.Size() != int64(len(mustEncode(t, spilled("4")))) {
+		t.Fatalf("spill file was not truncated: %v", err)
+	}
+	assertIds(t, popAll(t, s), "4")
+}
+
+func TestSpill_TruncatesAnIncompleteRecordOnRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpill(t, dir)
+	pushAll(t, s, "1", "2")
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process stopped while writing a record.
+	f, err := os.OpenFile(filepath.Join(dir, spillFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(mustEncode(t, spilled("lost"))[:10]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = openTestSpill(t, dir)
+	if s.count != 2 {
+		t.Fatalf("%d messages recovered, want 2", s.count)
+	}
+	pushAll(t, s, "3")
+	assertIds(t, popAll(t, s), "1", "2", "3")
+}
+
+func TestSpill_PrependsOlderMessages(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpill(t, dir)
+	pushAll(t, s, "3", "4", "5")
+	if err := s.pop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.prepend([]bufferedMessage{spilled("1"), spilled("2")}); err != nil {
+		t.Fatal(err)
+	}
+	pushAll(t, s, "6")
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpill(t, dir)
+	assertIds(t, popAll(t, s), "1", "2", "4", "5", "6")
+}
+
+func TestSpill_KeepsItsFileWhenPrependFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, spillFile)
+
+	s := openTestSpill(t, dir)
+	pushAll(t, s, "2")
+
+	// A directory can't be replaced by the rewritten file.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.prepend([]bufferedMessage{spilled("1")}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The spill is unchanged and its file is closed once.
+	assertIds(t, popAll(t, s), "2")
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file was not removed: %v", err)
+	}
+}
+
+func mustEncode(t *testing.T, m bufferedMessage) []byte {
+	t.Helper()
+
+	record, err := encodeSpillRecord(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
@@ -0,0 +1,67 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// RetryTopology describes a main queue with a delayed-retry queue and a parking-lot queue.
+// Messages published on the retry queue go back to the main queue after RetryDelay,
+// messages rejected without requeue by the main queue are dead-lettered to the parking-lot queue.
+type RetryTopology struct {
+	// Queue is the name of the main queue.
+	Queue string
+	// RetryDelay is the time spent by a message in the retry queue.
+	RetryDelay time.Duration
+	// Args are added to the arguments of the main queue.
+	Args amqp.Table
+}
+
+// RetryQueue returns the name of the retry queue.
+func (t RetryTopology) RetryQueue() string {
+	return t.Queue + ".retry"
+}
+
+// ParkingLotQueue returns the name of the parking-lot queue.
+func (t RetryTopology) ParkingLotQueue() string {
+	return t.Queue + ".parking-lot"
+}
+
+// DeclareRetryTopology declares the durable main, retry and parking-lot queues of the topology.
+func DeclareRetryTopology(ctx context.Context, c *Dialer, t RetryTopology) error {
+	if t.Queue == "" {
+		return fmt.Errorf("queue must be not empty")
+	}
+
+	if t.RetryDelay <= 0 {
+		return fmt.Errorf("retry delay must be positive")
+	}
+
+	if _, err := Queue(ctx, c, t.ParkingLotQueue(), true, false, false, false, amqp.Table{}); err != nil {
+		return err
+	}
+
+	if _, err := Queue(ctx, c, t.RetryQueue(), true, false, false, false, amqp.Table{
+		"x-message-ttl":             t.RetryDelay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": t.Queue,
+	}); err != nil {
+		return err
+	}
+
+	args := amqp.Table{}
+	for k, v := range t.Args {
+		args[k] = v
+	}
+	args["x-dead-letter-exchange"] = ""
+	args["x-dead-letter-routing-key"] = t.ParkingLotQueue()
+
+	if _, err := Queue(ctx, c, t.Queue, true, false, false, false, args); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/consumer"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// ErrReplyQueueLost is returned to pending calls when the reply queue is gone, usually after a reconnect.
+var ErrReplyQueueLost = fmt.Errorf("reply queue lost")
+
+// RPCClient publishes requests and waits for the replies matching their correlation id.
+// All calls share one exclusive server-named reply queue. The queue is declared again on every reconnect,
+// calls in-flight at that moment fail with ErrReplyQueueLost since their replies are routed to the lost queue.
+type RPCClient struct {
+	publisher *publisher.Publisher
+	consumer  *consumer.Consumer
+
+	publisherOpts []publisher.Option
+	consumerOpts  []consumer.Option
+
+	mu      sync.Mutex
+	replyTo string
+	readyCh chan struct{}
+	pending map[string]chan amqp.Delivery
+
+	closeCh chan struct{}
+}
+
+// RPCClientOption could be used to configure RPCClient
+type RPCClientOption func(c *RPCClient)
+
+// WithRPCPublisherOptions configure the publisher used to send requests.
+func WithRPCPublisherOptions(opts ...publisher.Option) RPCClientOption {
+	return func(c *RPCClient) {
+		c.publisherOpts = append(c.publisherOpts, opts...)
+	}
+}
+
+// WithRPCConsumerOptions configure the consumer of the reply queue, e.g. its init func.
+// The queue, the consume args, the handler and the notify channel are set by RPCClient and can't be overridden.
+func WithRPCConsumerOptions(opts ...consumer.Option) RPCClientOption {
+	return func(c *RPCClient) {
+		c.consumerOpts = append(c.consumerOpts, opts...)
+	}
+}
+
+// NewRPCClient returns RPCClient using connections of the Dialer.
+func NewRPCClient(d *Dialer, opts ...RPCClientOption) (*RPCClient, error) {
+	c := &RPCClient{
+		readyCh: make(chan struct{}),
+		pending: make(map[string]chan amqp.Delivery),
+		closeCh: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	p, err := d.Publisher(c.publisherOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	stateCh := make(chan consumer.State, 1)
+	cons, err := d.Consumer(append(c.consumerOpts,
+		consumer.WithDeclareQueue("", false, true, true, false, nil),
+		consumer.WithConsumeArgs("", true, true, false, false, nil),
+		consumer.WithHandler(consumer.HandlerFunc(c.handleReply)),
+		consumer.WithNotify(stateCh),
+	)...)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	c.publisher = p
+	c.consumer = cons
+
+	go c.watchState(stateCh)
+
+	return c, nil
+}
+
+// Call publishes the message with a generated correlation id and waits for the reply.
+// The message Context is replaced by ctx, use it to set a timeout.
+func (c *RPCClient) Call(ctx context.Context, msg publisher.Message) (amqp.Delivery, error) {
+	correlationID := uuid.NewString()
+	replyCh := make(chan amqp.Delivery, 1)
+
+	replyTo, err := c.register(ctx, correlationID, replyCh)
+	if err != nil {
+		return amqp.Delivery{}, err
+	}
+	defer c.forget(correlationID)
+
+	msg.Context = ctx
+	msg.ResultCh = nil
+	msg.Publishing.CorrelationId = correlationID
+	msg.Publishing.ReplyTo = replyTo
+
+	if err = c.publisher.Publish(msg); err != nil {
+		return amqp.Delivery{}, err
+	}
+
+	select {
+	case reply, ok := <-replyCh:
+		if !ok {
+			return amqp.Delivery{}, ErrReplyQueueLost
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return amqp.Delivery{}, ctx.Err()
+	case <-c.closeCh:
+		return amqp.Delivery{}, fmt.Errorf("rpc client closed")
+	}
+}
+
+// Close stops the publisher and the reply consumer.
+func (c *RPCClient) Close() {
+	c.publisher.Close()
+	c.consumer.Close()
+}
+
+// NotifyClosed notifies when both the publisher and the reply consumer are closed.
+func (c *RPCClient) NotifyClosed() <-chan struct{} {
+	return c.closeCh
+}
+
+func (c *RPCClient) handleReply(_ context.Context, msg amqp.Delivery) interface{} {
+	c.mu.Lock()
+	replyCh, ok := c.pending[msg.CorrelationId]
+	delete(c.pending, msg.CorrelationId)
+	c.mu.Unlock()
+
+	if ok {
+		replyCh <- msg
+	}
+
+	return nil
+}
+
+func (c *RPCClient) watchState(stateCh <-chan consumer.State) {
+	defer close(c.closeCh)
+	defer c.publisher.Close()
+
+	for {
+		select {
+		case state := <-stateCh:
+			c.mu.Lock()
+			if state.Ready != nil {
+				if c.replyTo != state.Ready.Queue {
+					c.failPending()
+				}
+				c.replyTo = state.Ready.Queue
+				select {
+				case <-c.readyCh:
+				default:
+					close(c.readyCh)
+				}
+			} else if c.replyTo != "" {
+				c.replyTo = ""
+				c.readyCh = make(chan struct{})
+				c.failPending()
+			}
+			c.mu.Unlock()
+		case <-c.consumer.NotifyClosed():
+			c.mu.Lock()
+			c.failPending()
+			c.mu.Unlock()
+			<-c.publisher.NotifyClosed()
+			return
+		}
+	}
+}
+
+func (c *RPCClient) waitReady(ctx context.Context) (string, error) {
+	for {
+		c.mu.Lock()
+		replyTo, readyCh := c.replyTo, c.readyCh
+		c.mu.Unlock()
+
+		if replyTo != "" {
+			return replyTo, nil
+		}
+
+		select {
+		case <-readyCh:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-c.closeCh:
+			return "", fmt.Errorf("rpc client closed")
+		}
+	}
+}
+
+// register adds the pending call once the reply queue is ready and returns the queue name.
+// The queue is checked again under the lock, so a call is never registered after the pending ones of its queue failed.
+func (c *RPCClient) register(ctx context.Context, correlationID string, replyCh chan amqp.Delivery) (string, error) {
+	for {
+		replyTo, err := c.waitReady(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		c.mu.Lock()
+		if c.replyTo == replyTo {
+			c.pending[correlationID] = replyCh
+			c.mu.Unlock()
+			return replyTo, nil
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *RPCClient) forget(correlationID string) {
+	c.mu.Lock()
+	delete(c.pending, correlationID)
+	c.mu.Unlock()
+}
+
+// failPending must be called with mu held.
+func (c *RPCClient) failPending() {
+	for correlationID, replyCh := range c.pending {
+		close(replyCh)
+		delete(c.pending, correlationID)
+	}
+}
@@ -0,0 +1,217 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/ereb-or-od/kenobi/pkg/configuration/interfaces"
+	"github.com/mitchellh/mapstructure"
+	"github.com/streadway/amqp"
+)
+
+type (
+	// Topology describes exchanges, queues and bindings declared together.
+	// Declarations are idempotent, so the topology could be declared on every connection.
+	// Policies are out of scope: they are set through the management API or rabbitmqctl, not AMQP.
+	// The limits they usually carry could be declared with the queue Args instead.
+	Topology struct {
+		Exchanges []ExchangeSpec `mapstructure:"exchanges"`
+		Queues    []QueueSpec    `mapstructure:"queues"`
+		Bindings  []BindingSpec  `mapstructure:"bindings"`
+	}
+
+	// ExchangeSpec describes an exchange. Kind is one of direct, fanout, topic, headers
+	// or any kind provided by a plugin, like x-delayed-message.
+	ExchangeSpec struct {
+		Name       string     `mapstructure:"name"`
+		Kind       string     `mapstructure:"kind"`
+		Durable    bool       `mapstructure:"durable"`
+		AutoDelete bool       `mapstructure:"autodelete"`
+		Internal   bool       `mapstructure:"internal"`
+		NoWait     bool       `mapstructure:"nowait"`
+		Args       amqp.Table `mapstructure:"args"`
+	}
+
+	// QueueSpec describes a queue. Args could set the queue type, like x-queue-type: quorum,
+	// or limits, like x-max-length and x-message-ttl.
+	QueueSpec struct {
+		Name       string     `mapstructure:"name"`
+		Durable    bool       `mapstructure:"durable"`
+		AutoDelete bool       `mapstructure:"autodelete"`
+		Exclusive  bool       `mapstructure:"exclusive"`
+		NoWait     bool       `mapstructure:"nowait"`
+		Args       amqp.Table `mapstructure:"args"`
+	}
+
+	// BindingSpec binds the Destination queue, or exchange when ToExchange is set, to the Source exchange.
+	BindingSpec struct {
+		Source      string     `mapstructure:"source"`
+		Destination string     `mapstructure:"destination"`
+		Key         string     `mapstructure:"key"`
+		ToExchange  bool       `mapstructure:"toexchange"`
+		NoWait      bool       `mapstructure:"nowait"`
+		Args        amqp.Table `mapstructure:"args"`
+	}
+)
+
+// WithTopology configure a topology declared by Dialer on every established connection,
+// before the connection is shared with consumers and publishers. The channel is opened as set by WithInitFunc.
+func WithTopology(t Topology) Option {
+	return func(c *Dialer) {
+		c.topologies = append(c.topologies, t)
+	}
+}
+
+// TopologyFromConfiguration reads the topology under the key of the configuration source, for example in YAML:
+//
+//	rabbitmq:
+//	  topology:
+//	    exchanges:
+//	      - name: orders
+//	        kind: topic
+//	        durable: true
+//	    queues:
+//	      - name: orders.created
+//	        durable: true
+//	        args:
+//	          x-queue-type: quorum
+//	    bindings:
+//	      - source: orders
+//	        destination: orders.created
+//	        key: orders.created.#
+func TopologyFromConfiguration(source interfaces.ConfigurationSource, key string) (Topology, error) {
+	var t Topology
+
+	value := source.GetValueByKey(key)
+	if value == nil {
+		return t, fmt.Errorf("topology %s not found", key)
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: func(from, to reflect.Type, data interface{}) (interface{}, error) {
+			if to != reflect.TypeOf(amqp.Table{}) {
+				return data, nil
+			}
+			return toTable(data)
+		},
+		WeaklyTypedInput: true,
+		Result:           &t,
+	})
+	if err != nil {
+		return t, err
+	}
+
+	if err = decoder.Decode(value); err != nil {
+		return t, fmt.Errorf("topology %s: %w", key, err)
+	}
+
+	return t, nil
+}
+
+// Declare declares the topology using a channel of the Dialer.
+func (t Topology) Declare(ctx context.Context, c *Dialer) error {
+	ch, err := c.Channel(ctx)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return t.declare(ch)
+}
+
+func (t Topology) declare(ch AMQPChannel) error {
+	var err error
+	for _, e := range t.Exchanges {
+		if err = ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, e.NoWait, e.Args); err != nil {
+			return fmt.Errorf("declare exchange %s: %w", e.Name, err)
+		}
+	}
+
+	for _, q := range t.Queues {
+		if _, err = ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, q.NoWait, q.Args); err != nil {
+			return fmt.Errorf("declare queue %s: %w", q.Name, err)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if b.ToExchange {
+			err = ch.ExchangeBind(b.Destination, b.Key, b.Source, b.NoWait, b.Args)
+		} else {
+			err = ch.QueueBind(b.Destination, b.Key, b.Source, b.NoWait, b.Args)
+		}
+		if err != nil {
+			return fmt.Errorf("bind %s to %s: %w", b.Destination, b.Source, err)
+		}
+	}
+
+	return nil
+}
+
+// declareTopologies declares the configured topologies on a new connection.
+func (c *Dialer) declareTopologies(conn AMQPConnection) error {
+	if len(c.topologies) == 0 {
+		return nil
+	}
+
+	ch, err := c.channel(conn)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, t := range c.topologies {
+		if err = t.declare(ch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toTable converts the maps decoded from a configuration file to amqp.Table.
+func toTable(data interface{}) (amqp.Table, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	table := amqp.Table{}
+	switch m := data.(type) {
+	case amqp.Table:
+		return m, nil
+	case map[string]interface{}:
+		for k, v := range m {
+			table[k] = toField(v)
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			table[fmt.Sprint(k)] = toField(v)
+		}
+	default:
+		return nil, fmt.Errorf("arguments must be a map, got %T", data)
+	}
+
+	return table, table.Validate()
+}
+
+func toField(v interface{}) interface{} {
+	switch f := v.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		table, _ := toTable(f)
+		return table
+	case []interface{}:
+		fields := make([]interface{}, len(f))
+		for i := range f {
+			fields[i] = toField(f[i])
+		}
+		return fields
+	case uint:
+		return int64(f)
+	case uint32:
+		return int64(f)
+	case uint64:
+		return int64(f)
+	default:
+		return v
+	}
+}
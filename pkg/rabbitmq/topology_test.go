@@ -0,0 +1,135 @@
+package rabbitmq_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ereb-or-od/kenobi/pkg/configuration/local_file"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/amqptest"
+	"github.com/ereb-or-od/kenobi/pkg/rabbitmq/publisher"
+	"github.com/streadway/amqp"
+)
+
+const topologyYAML = `
+rabbitmq:
+  topology:
+    exchanges:
+      - name: orders
+        kind: topic
+        durable: true
+    queues:
+      - name: orders.created
+        durable: true
+        args:
+          x-queue-type: quorum
+          x-max-length: 1000
+          x-dead-letter:
+            exchange: orders.dlx
+            keys: [created, failed]
+    bindings:
+      - source: orders
+        destination: orders.created
+        key: orders.created.#
+  invalid:
+    queues:
+      - name: orders.created
+        args: [x-max-length]
+`
+
+func loadTopology(t *testing.T) *rabbitmq.Topology {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(topologyYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := local_file.NewWithOptions("config", "yaml", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = rabbitmq.TopologyFromConfiguration(source, "rabbitmq.missing"); err == nil {
+		t.Fatal("expected an error for a missing topology")
+	}
+	if _, err = rabbitmq.TopologyFromConfiguration(source, "rabbitmq.invalid"); err == nil {
+		t.Fatal("expected an error for arguments which are not a map")
+	}
+
+	topology, err := rabbitmq.TopologyFromConfiguration(source, "rabbitmq.topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &topology
+}
+
+func TestTopologyFromConfiguration(t *testing.T) {
+	topology := loadTopology(t)
+
+	if len(topology.Exchanges) != 1 || len(topology.Queues) != 1 || len(topology.Bindings) != 1 {
+		t.Fatalf("unexpected topology %+v", topology)
+	}
+
+	if e := topology.Exchanges[0]; e.Name != "orders" || e.Kind != "topic" || !e.Durable || e.AutoDelete {
+		t.Fatalf("unexpected exchange %+v", e)
+	}
+
+	if b := topology.Bindings[0]; b.Source != "orders" || b.Destination != "orders.created" || b.Key != "orders.created.#" || b.ToExchange {
+		t.Fatalf("unexpected binding %+v", b)
+	}
+
+	q := topology.Queues[0]
+	if q.Name != "orders.created" || !q.Durable || q.Args["x-queue-type"] != "quorum" || q.Args["x-max-length"] != 1000 {
+		t.Fatalf("unexpected queue %+v", q)
+	}
+
+	// Nested maps and lists are converted to fields amqp could encode.
+	dlx, ok := q.Args["x-dead-letter"].(amqp.Table)
+	if !ok || dlx["exchange"] != "orders.dlx" {
+		t.Fatalf("unexpected nested table %#v", q.Args["x-dead-letter"])
+	}
+	if keys, ok := dlx["keys"].([]interface{}); !ok || len(keys) != 2 || keys[0] != "created" {
+		t.Fatalf("unexpected nested list %#v", dlx["keys"])
+	}
+	if err := q.Args.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopologyFromConfiguration_DeclaresOnEveryConnection(t *testing.T) {
+	topology := loadTopology(t)
+	b := amqptest.NewBroker()
+
+	d, err := rabbitmq.NewDialer(
+		rabbitmq.WithURL("amqp://fake"),
+		rabbitmq.WithAMQPDial(b.Dial),
+		rabbitmq.WithInitFunc(amqptest.DialerInitFunc),
+		rabbitmq.WithRetryPeriod(10*time.Millisecond),
+		rabbitmq.WithTopology(*topology),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	p, err := d.Publisher(publisher.WithConfirmation(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The message is routed through the declared exchange and binding.
+	if err = p.Publish(publisher.Message{Context: ctx, Exchange: "orders", Key: "orders.created.eu"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.QueueLen("orders.created"); n != 1 {
+		t.Fatalf("%d messages in the queue, want 1", n)
+	}
+}
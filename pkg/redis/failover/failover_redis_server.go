@@ -53,6 +53,18 @@ func (r failoverRedisServer) SetValue(ctx context.Context, key string, value int
 	return nil
 }
 
+func (r failoverRedisServer) SetValueIfNotExists(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	byteArray, err := r.marshaller.Marshall(&value)
+	if err != nil {
+		return false, err
+	}
+	commandResult := r.client.SetNX(ctx, key, byteArray, expiration)
+	if commandResult.Err() != nil {
+		return false, commandResult.Err()
+	}
+	return commandResult.Val(), nil
+}
+
 func New(logger logger.Logger, marshaller marshallers.Marshaller, options *RedisServerOptions) interfaces.RedisServer {
 	rdb := redis.NewFailoverClient(&redis.FailoverOptions{
 		MasterName:       options.MasterName,
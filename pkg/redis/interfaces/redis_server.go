@@ -8,5 +8,6 @@ import (
 type RedisServer interface{
 	GetValueByKey(ctx context.Context, key string, result interface{})  error
 	SetValue(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	SetValueIfNotExists(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
 	DeleteValueByKey(ctx context.Context, key string) error
 }
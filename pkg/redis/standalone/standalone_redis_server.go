@@ -53,6 +53,18 @@ func (r standaloneRedisServer) SetValue(ctx context.Context, key string, value i
 	return nil
 }
 
+func (r standaloneRedisServer) SetValueIfNotExists(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	byteArray, err := r.marshaller.Marshall(&value)
+	if err != nil {
+		return false, err
+	}
+	commandResult := r.client.SetNX(ctx, key, byteArray, expiration)
+	if commandResult.Err() != nil {
+		return false, commandResult.Err()
+	}
+	return commandResult.Val(), nil
+}
+
 func New(logger logger.Logger, marshaller marshallers.Marshaller, options *StandaloneRedisServerOptions) interfaces.RedisServer {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     options.Address,